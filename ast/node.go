@@ -2,6 +2,7 @@ package ast
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/westsi/molybdenum/lex"
@@ -23,11 +24,44 @@ type Expression interface {
 	expressionNode()
 }
 
+// TypeExpr is a type as written in the source. Names in it are left for a
+// later pass to resolve.
+type TypeExpr interface {
+	Node
+	typeNode()
+}
+
+// ImportDeclaration makes the package at Path available to the file, under
+// Alias if one is given and otherwise under the last element of its path.
+type ImportDeclaration struct {
+	Token lex.LexedTok
+	Path  string
+	Alias *Identifier
+}
+
+func (i *ImportDeclaration) statementNode() {}
+func (i *ImportDeclaration) NType() string  { return "ImportDeclaration" }
+func (i *ImportDeclaration) Literal() string {
+	if i.Alias == nil {
+		return fmt.Sprintf("token: %s, path: %s\n", i.Token.Tok.String(), i.Path)
+	}
+	return fmt.Sprintf("token: %s, path: %s, alias: %s\n", i.Token.Tok.String(), i.Path, i.Alias.Literal())
+}
+func (i *ImportDeclaration) String() string {
+	if i.Alias == nil {
+		return fmt.Sprintf("(import %s)", i.Path)
+	}
+	return fmt.Sprintf("(import %s as %s)", i.Path, i.Alias.String())
+}
+
 type VarStatement struct {
 	Token lex.LexedTok
 	Name  *Identifier
 	Value Expression
-	Type  *Type
+	Type  TypeExpr
+	// Doc is the text of the doc comments directly before the statement
+	Doc        string
+	Directives []*Directive
 }
 
 func (vs *VarStatement) statementNode() {}
@@ -53,12 +87,15 @@ func (i *Identifier) String() string {
 	return i.Token.Val
 }
 
+// Type is a type referred to by name, either a built-in type or one declared
+// by the user.
 type Type struct {
 	Token lex.LexedTok
 	Value string
 }
 
 func (t *Type) expressionNode() {}
+func (t *Type) typeNode()       {}
 func (t *Type) Literal() string {
 	return fmt.Sprintf("{%s, %s}", t.Token.Tok.String(), t.Value)
 }
@@ -66,20 +103,209 @@ func (t *Type) String() string {
 	return t.Value
 }
 
+// QualifiedType is a type declared in another package, such as geo.Point.
+type QualifiedType struct {
+	Token   lex.LexedTok
+	Package *Identifier
+	Name    *Identifier
+}
+
+func (q *QualifiedType) typeNode() {}
+func (q *QualifiedType) Literal() string {
+	return fmt.Sprintf("token: %s, package: %s, name: %s\n", q.Token.Tok.String(), q.Package.Literal(), q.Name.Literal())
+}
+func (q *QualifiedType) String() string {
+	return fmt.Sprintf("%s.%s", q.Package.String(), q.Name.String())
+}
+
+// ArrayType is an array type [N]T, or a slice type []T when Len is nil.
+type ArrayType struct {
+	Token lex.LexedTok
+	Len   Expression
+	Elem  TypeExpr
+}
+
+func (a *ArrayType) typeNode() {}
+func (a *ArrayType) Literal() string {
+	if a.Len == nil {
+		return fmt.Sprintf("token: %s, elem: %s\n", a.Token.Tok.String(), a.Elem.Literal())
+	}
+	return fmt.Sprintf("token: %s, len: %s, elem: %s\n", a.Token.Tok.String(), a.Len.Literal(), a.Elem.Literal())
+}
+func (a *ArrayType) String() string {
+	if a.Len == nil {
+		return fmt.Sprintf("[]%s", a.Elem.String())
+	}
+	return fmt.Sprintf("[%s]%s", a.Len.String(), a.Elem.String())
+}
+
 type ReturnStatement struct {
-	Token       lex.LexedTok
+	Token lex.LexedTok
+	// ReturnValue is nil for a bare return
 	ReturnValue Expression
 }
 
 func (ret *ReturnStatement) statementNode() {}
 func (ret *ReturnStatement) NType() string  { return "ReturnStatement" }
 func (ret *ReturnStatement) Literal() string {
+	if ret.ReturnValue == nil {
+		return fmt.Sprintf("token: %s\n", ret.Token.Tok.String())
+	}
 	return fmt.Sprintf("token: %s, value: %s\n", ret.Token.Tok.String(), ret.ReturnValue.Literal())
 }
 func (ret *ReturnStatement) String() string {
+	if ret.ReturnValue == nil {
+		return "(return)"
+	}
 	return fmt.Sprintf("(return %s)", ret.ReturnValue.String())
 }
 
+// AssignStatement assigns to a variable, an element or a field, either
+// plainly with = or with a compound operator such as +=.
+type AssignStatement struct {
+	Token    lex.LexedTok
+	Target   Expression
+	Operator string
+	Value    Expression
+}
+
+func (as *AssignStatement) statementNode() {}
+func (as *AssignStatement) NType() string  { return "AssignStatement" }
+func (as *AssignStatement) Literal() string {
+	return fmt.Sprintf("token: %s, target: %s, operator: %s, value: %s\n", as.Token.Tok.String(), as.Target.Literal(), as.Operator, as.Value.Literal())
+}
+func (as *AssignStatement) String() string {
+	return fmt.Sprintf("(%s %s %s)", as.Target.String(), as.Operator, as.Value.String())
+}
+
+type WhileStatement struct {
+	Token     lex.LexedTok
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (w *WhileStatement) statementNode() {}
+func (w *WhileStatement) NType() string  { return "WhileStatement" }
+func (w *WhileStatement) Literal() string {
+	return fmt.Sprintf("token: %s, condition: %s, body: %s\n", w.Token.Tok.String(), w.Condition.Literal(), w.Body.Literal())
+}
+func (w *WhileStatement) String() string {
+	return fmt.Sprintf("(while %s {%s})", w.Condition.String(), w.Body.String())
+}
+
+// ForStatement is a C-style for loop. Any of Init, Condition and Post may be
+// left out, and a loop without a Condition runs until it is broken out of.
+type ForStatement struct {
+	Token     lex.LexedTok
+	Init      Statement
+	Condition Expression
+	Post      Statement
+	Body      *BlockStatement
+}
+
+func (f *ForStatement) statementNode() {}
+func (f *ForStatement) NType() string  { return "ForStatement" }
+func (f *ForStatement) Literal() string {
+	parts := make([]string, 3)
+	if f.Init != nil {
+		parts[0] = f.Init.Literal()
+	}
+	if f.Condition != nil {
+		parts[1] = f.Condition.Literal()
+	}
+	if f.Post != nil {
+		parts[2] = f.Post.Literal()
+	}
+	return fmt.Sprintf("token: %s, init: %s, condition: %s, post: %s, body: %s\n", f.Token.Tok.String(), parts[0], parts[1], parts[2], f.Body.Literal())
+}
+func (f *ForStatement) String() string {
+	parts := make([]string, 3)
+	if f.Init != nil {
+		parts[0] = f.Init.String()
+	}
+	if f.Condition != nil {
+		parts[1] = f.Condition.String()
+	}
+	if f.Post != nil {
+		parts[2] = f.Post.String()
+	}
+	return fmt.Sprintf("(for %s {%s})", strings.Join(parts, "; "), f.Body.String())
+}
+
+// ForCondStatement is a for loop with only a condition, which runs for as
+// long as the condition holds.
+type ForCondStatement struct {
+	Token     lex.LexedTok
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (f *ForCondStatement) statementNode() {}
+func (f *ForCondStatement) NType() string  { return "ForCondStatement" }
+func (f *ForCondStatement) Literal() string {
+	return fmt.Sprintf("token: %s, condition: %s, body: %s\n", f.Token.Tok.String(), f.Condition.Literal(), f.Body.Literal())
+}
+func (f *ForCondStatement) String() string {
+	return fmt.Sprintf("(for %s {%s})", f.Condition.String(), f.Body.String())
+}
+
+// ForInStatement loops over the elements of a collection or the values of a
+// range, binding each in turn to Variable.
+type ForInStatement struct {
+	Token    lex.LexedTok
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (f *ForInStatement) statementNode() {}
+func (f *ForInStatement) NType() string  { return "ForInStatement" }
+func (f *ForInStatement) Literal() string {
+	return fmt.Sprintf("token: %s, variable: %s, iterable: %s, body: %s\n", f.Token.Tok.String(), f.Variable.Literal(), f.Iterable.Literal(), f.Body.Literal())
+}
+func (f *ForInStatement) String() string {
+	return fmt.Sprintf("(for %s in %s {%s})", f.Variable.String(), f.Iterable.String(), f.Body.String())
+}
+
+// BranchStatement is a break or continue, which applies to the loop with the
+// given label or, without one, to the innermost loop.
+type BranchStatement struct {
+	Token lex.LexedTok
+	Label *Identifier
+}
+
+func (b *BranchStatement) statementNode() {}
+func (b *BranchStatement) NType() string  { return "BranchStatement" }
+func (b *BranchStatement) Literal() string {
+	if b.Label == nil {
+		return fmt.Sprintf("token: %s\n", b.Token.Tok.String())
+	}
+	return fmt.Sprintf("token: %s, label: %s\n", b.Token.Tok.String(), b.Label.Literal())
+}
+func (b *BranchStatement) String() string {
+	if b.Label == nil {
+		return fmt.Sprintf("(%s)", b.Token.Val)
+	}
+	return fmt.Sprintf("(%s %s)", b.Token.Val, b.Label.String())
+}
+
+// LabeledStatement is a loop with a label that break and continue statements
+// inside it can refer to.
+type LabeledStatement struct {
+	Token     lex.LexedTok
+	Label     *Identifier
+	Statement Statement
+}
+
+func (l *LabeledStatement) statementNode() {}
+func (l *LabeledStatement) NType() string  { return "LabeledStatement" }
+func (l *LabeledStatement) Literal() string {
+	return fmt.Sprintf("token: %s, label: %s, statement: %s\n", l.Token.Tok.String(), l.Label.Literal(), l.Statement.Literal())
+}
+func (l *LabeledStatement) String() string {
+	return fmt.Sprintf("(%s: %s)", l.Label.String(), l.Statement.String())
+}
+
 type ExpressionStatement struct {
 	Token      lex.LexedTok
 	Expression Expression
@@ -108,6 +334,45 @@ func (i *IntegerLiteral) String() string {
 	return fmt.Sprintf("%d", i.Value)
 }
 
+type FloatLiteral struct {
+	Token lex.LexedTok
+	Value float64
+}
+
+func (f *FloatLiteral) expressionNode() {}
+func (f *FloatLiteral) Literal() string {
+	return fmt.Sprintf("token: %s, value: %g\n", f.Token.Tok.String(), f.Value)
+}
+func (f *FloatLiteral) String() string {
+	return fmt.Sprintf("%g", f.Value)
+}
+
+type StringLiteral struct {
+	Token lex.LexedTok
+	Value string
+}
+
+func (s *StringLiteral) expressionNode() {}
+func (s *StringLiteral) Literal() string {
+	return fmt.Sprintf("token: %s, value: %q\n", s.Token.Tok.String(), s.Value)
+}
+func (s *StringLiteral) String() string {
+	return strconv.Quote(s.Value)
+}
+
+type CharLiteral struct {
+	Token lex.LexedTok
+	Value rune
+}
+
+func (c *CharLiteral) expressionNode() {}
+func (c *CharLiteral) Literal() string {
+	return fmt.Sprintf("token: %s, value: %q\n", c.Token.Tok.String(), c.Value)
+}
+func (c *CharLiteral) String() string {
+	return fmt.Sprintf("%q", c.Value)
+}
+
 type PrefixExpression struct {
 	Token    lex.LexedTok
 	Operator string
@@ -137,6 +402,39 @@ func (i *InfixExpression) String() string {
 	return fmt.Sprintf("(%s %s %s)", i.Left.String(), i.Operator, i.Right.String())
 }
 
+// RangeExpression is the range of integers from Start up to End, which
+// includes End when written with ..= rather than ..
+type RangeExpression struct {
+	Token     lex.LexedTok
+	Start     Expression
+	End       Expression
+	Inclusive bool
+}
+
+func (r *RangeExpression) expressionNode() {}
+func (r *RangeExpression) Literal() string {
+	return fmt.Sprintf("token: %s, start: %s, end: %s, inclusive: %t\n", r.Token.Tok.String(), r.Start.Literal(), r.End.Literal(), r.Inclusive)
+}
+func (r *RangeExpression) String() string {
+	return fmt.Sprintf("(%s %s %s)", r.Start.String(), r.Token.Val, r.End.String())
+}
+
+// GroupedExpression is an expression in parentheses. It is kept in the tree
+// so that the grouping can be recovered from it, but its String is that of
+// the expression inside, which already shows how it is grouped.
+type GroupedExpression struct {
+	Token      lex.LexedTok
+	Expression Expression
+}
+
+func (g *GroupedExpression) expressionNode() {}
+func (g *GroupedExpression) Literal() string {
+	return fmt.Sprintf("token: %s, expression: %s\n", g.Token.Tok.String(), g.Expression.Literal())
+}
+func (g *GroupedExpression) String() string {
+	return g.Expression.String()
+}
+
 type Boolean struct {
 	Token lex.LexedTok
 	Value bool
@@ -154,11 +452,18 @@ type IfExpression struct {
 	Token       lex.LexedTok
 	Condition   Expression
 	Consequence *BlockStatement
-	Alternative *BlockStatement
+	// Alternative is the block after else, or the IfExpression after else if
+	// when the if continues a chain. It is nil when there is no else.
+	Alternative Statement
 }
 
 func (i *IfExpression) expressionNode() {}
+func (i *IfExpression) statementNode()  {}
+func (i *IfExpression) NType() string   { return "IfExpression" }
 func (i *IfExpression) Literal() string {
+	if i.Alternative == nil {
+		return fmt.Sprintf("token: %s, condition: %s, consequence: %s\n", i.Token.Tok.String(), i.Condition.Literal(), i.Consequence.Literal())
+	}
 	return fmt.Sprintf("token: %s, condition: %s, consequence: %s, alternative: %s\n", i.Token.Tok.String(), i.Condition.Literal(), i.Consequence.Literal(), i.Alternative.Literal())
 }
 func (i *IfExpression) String() string {
@@ -193,8 +498,13 @@ func (b *BlockStatement) String() string {
 type FunctionDefinition struct {
 	Token      lex.LexedTok
 	Parameters []*Parameter
+	// ReturnType is nil for functions that return nothing
+	ReturnType TypeExpr
 	Body       *BlockStatement
 	Name       *Identifier
+	// Doc is the text of the doc comments directly before the definition
+	Doc        string
+	Directives []*Directive
 }
 
 func (f *FunctionDefinition) expressionNode() {}
@@ -208,13 +518,16 @@ func (f *FunctionDefinition) String() string {
 	for _, p := range f.Parameters {
 		ps = append(ps, p.String())
 	}
+	if f.ReturnType != nil {
+		return fmt.Sprintf("(func %s (%s) %s {%s})", f.Name.String(), strings.Join(ps, ", "), f.ReturnType.String(), f.Body.String())
+	}
 	return fmt.Sprintf("(func %s (%s) {%s})", f.Name.String(), strings.Join(ps, ", "), f.Body.String())
 }
 
 type Parameter struct {
 	Token lex.LexedTok
 	Name  *Identifier
-	Type  *Type
+	Type  TypeExpr
 }
 
 func (p *Parameter) expressionNode() {}
@@ -222,7 +535,7 @@ func (p *Parameter) Literal() string {
 	return fmt.Sprintf("token: %s, name: %s, type: %s\n", p.Token.Tok.String(), p.Name.Literal(), p.Type.Literal())
 }
 func (p *Parameter) String() string {
-	return fmt.Sprintf("%s %s", p.Name.String(), p.Type.String())
+	return fmt.Sprintf("%s %s", p.Type.String(), p.Name.String())
 }
 
 type CallExpression struct {
@@ -243,12 +556,43 @@ func (c *CallExpression) String() string {
 	return fmt.Sprintf("(%s(%s))", c.Function.String(), strings.Join(args, ", "))
 }
 
+type IndexExpression struct {
+	Token lex.LexedTok
+	Left  Expression
+	Index Expression
+}
+
+func (i *IndexExpression) expressionNode() {}
+func (i *IndexExpression) Literal() string {
+	return fmt.Sprintf("token: %s, left: %s, index: %s\n", i.Token.Tok.String(), i.Left.Literal(), i.Index.Literal())
+}
+func (i *IndexExpression) String() string {
+	return fmt.Sprintf("(%s[%s])", i.Left.String(), i.Index.String())
+}
+
+// SelectorExpression selects a field of a value, or a name exported by a
+// package, as in x.Name.
+type SelectorExpression struct {
+	Token    lex.LexedTok
+	Left     Expression
+	Selector *Identifier
+}
+
+func (s *SelectorExpression) expressionNode() {}
+func (s *SelectorExpression) Literal() string {
+	return fmt.Sprintf("token: %s, left: %s, selector: %s\n", s.Token.Tok.String(), s.Left.Literal(), s.Selector.Literal())
+}
+func (s *SelectorExpression) String() string {
+	return fmt.Sprintf("%s.%s", s.Left.String(), s.Selector.String())
+}
+
 type EntrypointFunctionDefinition struct {
 	Token lex.LexedTok
 	// Entrypoint functions have no parameters
 	// In addition, they should never be called by the user - calls for them are handled by the compiler
-	Body *BlockStatement
-	Name *Identifier
+	Body       *BlockStatement
+	Name       *Identifier
+	Directives []*Directive
 }
 
 func (e *EntrypointFunctionDefinition) expressionNode() {}
@@ -260,3 +604,25 @@ func (e *EntrypointFunctionDefinition) Literal() string {
 func (e *EntrypointFunctionDefinition) String() string {
 	return fmt.Sprintf("(entrypoint %s {%s})", e.Name.String(), e.Body.String())
 }
+
+// Directive is a compiler directive such as @inline or @deprecated("...")
+// applied to the declaration that follows it.
+type Directive struct {
+	Token     lex.LexedTok
+	Name      string
+	Arguments []Expression
+}
+
+func (d *Directive) Literal() string {
+	return fmt.Sprintf("token: %s, name: %s, arguments: %s\n", d.Token.Tok.String(), d.Name, d.Arguments)
+}
+func (d *Directive) String() string {
+	if d.Arguments == nil {
+		return "@" + d.Name
+	}
+	args := []string{}
+	for _, arg := range d.Arguments {
+		args = append(args, arg.String())
+	}
+	return fmt.Sprintf("@%s(%s)", d.Name, strings.Join(args, ", "))
+}
@@ -1,267 +1,901 @@
 package lex
 
 import (
-	"bufio"
+	"fmt"
 	"io"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
-type Position struct {
-	line int
-	col  int
+// Diagnostic is a problem found while lexing, reported at the position of
+// the token it belongs to.
+type Diagnostic struct {
+	Pos Position
+	Msg string
 }
 
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Msg)
+}
+
+// Lexer turns source text into tokens. The whole of the source is held in
+// memory, and the values of tokens are substrings of it, so lexing a token
+// only allocates when its value has to be decoded.
 type Lexer struct {
-	pos    Position
-	reader *bufio.Reader
+	src string
+	// pos is the position of the last rune read, and prev the position
+	// before it, which backup returns to. off is the offset of the next
+	// byte to be read and lineStart the offset of the current line.
+	pos       Position
+	prev      Position
+	off       int
+	lineStart int
+	file      *File
+	errors    []Diagnostic
+
+	// ioErr is the error that stopped the source from being read in full.
+	// It is reported when lexing reaches the end of what was read.
+	ioErr error
+	// invalid reports whether the last rune read was an invalid UTF-8
+	// encoding, and reread whether it was already read once before a backup.
+	invalid bool
+	reread  bool
+
+	mode Mode
+	// triviaStart is the offset just past the last token returned.
+	triviaStart int
+	// insertSemi is set when a newline or the end of the input would end
+	// a statement at this point.
+	insertSemi bool
+	// done is set once Next has returned EOF
+	done bool
 }
 
+// Mode controls optional lexer behaviour.
+type Mode uint
+
+const (
+	// PreserveTrivia attaches the whitespace, comments and blank lines
+	// around each token to it as trivia, so that the source can be rebuilt
+	// from the tokens. Comments are not returned as tokens of their own in
+	// this mode, but doc comments still are.
+	PreserveTrivia Mode = 1 << iota
+)
+
+// NewLexer returns a lexer for everything that can be read from reader. An
+// error reading it is reported once the lexer reaches the point it failed at.
+//
+// Token values are slices of the source, so reader is read to the end into
+// memory before the first token is lexed; the lexer does not stream its
+// input. Sources already in memory can be lexed with NewLexerBytes without
+// going through a reader.
 func NewLexer(reader io.Reader) *Lexer {
+	var src strings.Builder
+	_, err := io.Copy(&src, reader)
+	l := newLexer(src.String())
+	l.ioErr = err
+	return l
+}
+
+// NewLexerBytes returns a lexer for src. The lexer keeps its own copy of src,
+// so src may be changed afterwards.
+func NewLexerBytes(src []byte) *Lexer {
+	return newLexer(string(src))
+}
+
+func newLexer(src string) *Lexer {
 	return &Lexer{
-		reader: bufio.NewReader(reader),
-		pos:    Position{line: 1, col: 0},
+		src: src,
+		pos: Position{Line: 1, Column: 0},
+	}
+}
+
+// NewFileLexer returns a lexer for the contents of file. Token positions carry
+// the file's name, and the lexer fills in the file's line table as it goes.
+func NewFileLexer(file *File, reader io.Reader) *Lexer {
+	l := NewLexer(reader)
+	l.file = file
+	l.pos.Filename = file.Name()
+	return l
+}
+
+// Errors returns the diagnostics reported so far.
+func (l *Lexer) Errors() []Diagnostic {
+	return l.errors
+}
+
+func (l *Lexer) errorf(pos Position, format string, args ...interface{}) {
+	l.errors = append(l.errors, Diagnostic{Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+// SetMode sets the lexer's mode. It should be called before the first token
+// is lexed.
+func (l *Lexer) SetMode(mode Mode) {
+	l.mode = mode
+}
+
+func (l *Lexer) Lex() LexedTok {
+	tok := l.lex()
+	if l.mode&PreserveTrivia != 0 {
+		// comments are skipped over to become trivia
+		for tok.Tok == COMMENT {
+			tok = l.lex()
+		}
+	}
+
+	tok.Raw = l.src[tok.Pos.Offset:l.off]
+	tok.End = l.endPos()
+	if l.mode&PreserveTrivia != 0 {
+		tok.Leading = l.src[l.triviaStart:tok.Pos.Offset]
+		if !endsLine(tok) && tok.Tok != EOF {
+			tok.Trailing = l.lexTrailingTrivia()
+		}
 	}
+
+	if tok.Tok != COMMENT && tok.Tok != DOCCOMMENT {
+		l.insertSemi = endsStatement[tok.Tok]
+	}
+	l.triviaStart = l.off
+	return tok
+}
+
+// endsLine reports whether tok is the newline that ended a statement. The
+// semicolon before a comment running over lines is written as nothing at all,
+// so it does not end its line.
+func endsLine(tok LexedTok) bool {
+	return tok.Tok == SEMICOLON && tok.Raw == "\n"
 }
 
-func (l *Lexer) Lex() (Position, Token, string) {
+// text returns the source from start up to the next byte to be read.
+func (l *Lexer) text(start int) string {
+	return l.src[start:l.off]
+}
+
+// endPos returns the position just past the last rune read.
+func (l *Lexer) endPos() Position {
+	end := l.pos
+	end.Column = l.off - l.lineStart + 1
+	end.Offset = l.off
+	return end
+}
+
+func (l *Lexer) lex() LexedTok {
 	// keep looping until we return a token
 	for {
-		r, _, err := l.reader.ReadRune()
-		if err != nil {
-			if err == io.EOF {
-				return l.pos, EOF, "EOF"
+		r, ok := l.read()
+		if !ok {
+			if l.insertSemi {
+				return LexedTok{Pos: l.endPos(), Tok: SEMICOLON, Val: "EOF"}
 			}
-
-			// at this point there isn't much we can do, and the compiler
-			// should just return the raw error to the user
-			panic(err)
+			return LexedTok{Pos: l.endPos(), Tok: EOF, Val: "EOF"}
 		}
 
-		l.pos.col++
-
 		switch r {
 		case '\n':
+			startPos := l.pos
 			l.resetPosition()
-			return l.pos, NEWLINE, string(r)
+			if !l.insertSemi {
+				continue
+			}
+			return NewLexedTok(startPos, SEMICOLON, l.text(startPos.Offset))
+		case ';':
+			return NewLexedTok(l.pos, SEMICOLON, l.text(l.pos.Offset))
 		case '+':
-			return l.pos, ADD, string(r)
+			startPos := l.pos
+			tok, lit := l.lexOperator(r, ADD)
+			return NewLexedTok(startPos, tok, lit)
 		case '*':
-			return l.pos, MUL, string(r)
+			startPos := l.pos
+			tok, lit := l.lexOperator(r, MUL)
+			return NewLexedTok(startPos, tok, lit)
 		case '-':
-			return l.pos, SUB, string(r)
+			startPos := l.pos
+			tok, lit := l.lexOperator(r, SUB)
+			return NewLexedTok(startPos, tok, lit)
 		case '/':
-			sym, val := l.lexSlash(string(r))
-			return l.pos, sym, val
+			startPos := l.pos
+			tok, val := l.lexSlash(r)
+			return NewLexedTok(startPos, tok, val)
 		case '%':
-			return l.pos, MOD, string(r)
+			startPos := l.pos
+			tok, lit := l.lexOperator(r, MOD)
+			return NewLexedTok(startPos, tok, lit)
 		case '=':
-			t, s := l.lexEquals(r)
-			return l.pos, t, s
+			startPos := l.pos
+			tok, lit := l.lexOperator(r, ASSIGN)
+			return NewLexedTok(startPos, tok, lit)
 		case '!':
-			return l.pos, NOT, string(r)
+			startPos := l.pos
+			tok, lit := l.lexOperator(r, NOT)
+			return NewLexedTok(startPos, tok, lit)
 		case '<':
-			return l.pos, LT, string(r)
+			startPos := l.pos
+			tok, lit := l.lexOperator(r, LT)
+			return NewLexedTok(startPos, tok, lit)
 		case '>':
-			return l.pos, GT, string(r)
+			startPos := l.pos
+			tok, lit := l.lexOperator(r, GT)
+			return NewLexedTok(startPos, tok, lit)
+		case '&', '|':
+			// only valid as the first half of && and ||
+			startPos := l.pos
+			tok, lit := l.lexOperator(r, ILLEGAL)
+			if tok == ILLEGAL {
+				l.errorf(startPos, "unexpected character %q", r)
+			}
+			return NewLexedTok(startPos, tok, lit)
 		case '(':
-			return l.pos, LPAREN, string(r)
+			return NewLexedTok(l.pos, LPAREN, l.text(l.pos.Offset))
 		case ')':
-			return l.pos, RPAREN, string(r)
+			return NewLexedTok(l.pos, RPAREN, l.text(l.pos.Offset))
 		case ',':
-			return l.pos, COMMA, string(r)
+			return NewLexedTok(l.pos, COMMA, l.text(l.pos.Offset))
+		case ':':
+			return NewLexedTok(l.pos, COLON, l.text(l.pos.Offset))
 		case '[':
-			return l.pos, LSQRBRAC, string(r)
+			return NewLexedTok(l.pos, LSQRBRAC, l.text(l.pos.Offset))
 		case ']':
-			return l.pos, RSQRBRAC, string(r)
+			return NewLexedTok(l.pos, RSQRBRAC, l.text(l.pos.Offset))
 		case '.':
-			return l.pos, DOT, string(r)
+			startPos := l.pos
+			tok, lit := l.lexDot()
+			return NewLexedTok(startPos, tok, lit)
 		case '{':
-			return l.pos, BLOCKSTART, string(r)
+			return NewLexedTok(l.pos, BLOCKSTART, l.text(l.pos.Offset))
 		case '}':
-			return l.pos, BLOCKEND, string(r)
+			return NewLexedTok(l.pos, BLOCKEND, l.text(l.pos.Offset))
 		case '@':
 			startPos := l.pos
-			l.backup()
-			lit := l.lexCompilerInstruction()
-			if lit == "@import" {
-				return startPos, IMPORT, lit
-			} else {
-				return startPos, ILLEGAL, lit
-			}
+			tok, name := l.lexCompilerInstruction(startPos)
+			return NewLexedTok(startPos, tok, name)
 		case '"':
 			startPos := l.pos
-			lit := l.lexString()
-			return startPos, STRINGLITERAL, lit
+			tok, val := l.lexString()
+			return NewLexedTok(startPos, tok, val)
+		case '`':
+			startPos := l.pos
+			tok, val := l.lexRawString()
+			return NewLexedTok(startPos, tok, val)
+		case '\'':
+			startPos := l.pos
+			tok, val := l.lexChar()
+			return NewLexedTok(startPos, tok, val)
 		default:
 			if unicode.IsSpace(r) {
 				continue
-			} else if unicode.IsDigit(r) {
+			} else if isDecimal(r) {
 				startPos := l.pos
 				l.backup()
-				lit := l.lexInt()
-				return startPos, INTLITERAL, lit
-			} else if unicode.IsLetter(r) {
+				tok, lit := l.lexNumber(startPos)
+				return NewLexedTok(startPos, tok, lit)
+			} else if isLetter(r) {
 				startPos := l.pos
 				l.backup()
 				lit := l.lexIdent()
-				// need to check if it's a keyword
-				for _, keyword := range keywords {
-					if keyword == lit {
-						return startPos, kwmap[keyword], lit
-					}
+				// need to check if it's a keyword or boolean value
+				if tok, ok := kwmap[lit]; ok {
+					return NewLexedTok(startPos, tok, lit)
 				}
 				// need to check if it's a type annotation
-				for _, tannot := range types {
-					if tannot == lit {
-						return startPos, TYPEANNOT, lit
-					}
+				if types[lit] {
+					return NewLexedTok(startPos, TYPEANNOT, lit)
 				}
-				// need to check if it's a boolean value
-				if lit == "true" {
-					return startPos, TRUE, lit
-				} else if lit == "false" {
-					return startPos, FALSE, lit
-				}
-				return startPos, IDENT, lit
+				return NewLexedTok(startPos, IDENT, lit)
 			} else {
-				return l.pos, ILLEGAL, string(r)
+				// invalid encodings have already been reported by read
+				if !l.invalid {
+					l.errorf(l.pos, "unexpected character %q", r)
+				}
+				return NewLexedTok(l.pos, ILLEGAL, l.text(l.pos.Offset))
 			}
 		}
-		return l.pos, ILLEGAL, string(r)
 	}
 }
 
 func (l *Lexer) resetPosition() {
-	l.pos.line++
-	l.pos.col = 0
+	l.pos.Line++
+	l.pos.Column = 0
+	l.lineStart = l.off
+	if l.file != nil {
+		l.file.AddLine(l.off)
+	}
 }
 
-func (l *Lexer) backup() {
-	if err := l.reader.UnreadRune(); err != nil {
-		panic(err)
+// read reads the next rune and advances the position, returning false once
+// the input is exhausted. If the source could not be read in full, the error
+// is reported the first time its end is reached. Invalid UTF-8 is reported
+// where it is found but still returned as utf8.RuneError so that lexing can
+// carry on.
+func (l *Lexer) read() (rune, bool) {
+	if l.off >= len(l.src) {
+		if l.ioErr != nil {
+			l.errorf(l.pos, "read error: %v", l.ioErr)
+			l.ioErr = nil
+		}
+		return 0, false
+	}
+	r, size := rune(l.src[l.off]), 1
+	if r >= utf8.RuneSelf {
+		r, size = utf8.DecodeRuneInString(l.src[l.off:])
 	}
 
-	l.pos.col--
+	l.prev = l.pos
+	l.pos.Column = l.off - l.lineStart + 1
+	l.pos.Offset = l.off
+	l.off += size
+	l.invalid = r == utf8.RuneError && size == 1
+	if l.invalid && !l.reread {
+		l.errorf(l.pos, "invalid UTF-8 encoding")
+	}
+	l.reread = false
+	return r, true
+}
+
+// backup unreads the last rune read. It must only follow a successful read,
+// and cannot be used twice in a row.
+func (l *Lexer) backup() {
+	l.off = l.pos.Offset
+	l.pos = l.prev
+	l.reread = true
 }
 
-func (l *Lexer) lexInt() string {
-	var lit string
+// lexDigits lexes a run of digits valid in base, along with any digit
+// separators. Binary and octal literals consume every decimal digit so that
+// an out-of-range digit is reported instead of splitting the literal.
+func (l *Lexer) lexDigits(base int) {
 	for {
-		r, _, err := l.reader.ReadRune()
-		if err != nil {
-			if err == io.EOF {
-				return lit
-			}
+		r, ok := l.read()
+		if !ok {
+			return
 		}
 
-		l.pos.col++
-		if unicode.IsDigit(r) {
-			lit = lit + string(r)
-		} else {
+		if r != '_' && !isDecimal(r) && (base != 16 || !isHex(r)) {
 			l.backup()
-			return lit
+			return
+		}
+	}
+}
+
+// lexNumber lexes an integer or floating point literal. A '.' only starts a
+// fraction when it is followed by a digit, and an exponent is only consumed
+// when it is followed by digits (optionally signed), so neither is swallowed
+// from whatever comes after the number. Malformed literals are reported and
+// returned as ILLEGAL with their original spelling.
+func (l *Lexer) lexNumber(start Position) (Token, string) {
+	base := 10
+	if l.peekIs(0, '0') {
+		switch {
+		case l.peekIs(1, 'x') || l.peekIs(1, 'X'):
+			base = 16
+		case l.peekIs(1, 'b') || l.peekIs(1, 'B'):
+			base = 2
+		case l.peekIs(1, 'o') || l.peekIs(1, 'O'):
+			base = 8
+		}
+		if base != 10 {
+			l.skip(2)
+		}
+	}
+	l.lexDigits(base)
+	tok := INTLITERAL
+
+	if base == 10 {
+		if l.peekIs(0, '.') && l.peekDigit(1) {
+			l.skip(1)
+			l.lexDigits(10)
+			tok = FLOATLITERAL
+		}
+
+		if l.peekIs(0, 'e') || l.peekIs(0, 'E') {
+			if l.peekDigit(1) {
+				l.skip(1)
+				l.lexDigits(10)
+				tok = FLOATLITERAL
+			} else if (l.peekIs(1, '+') || l.peekIs(1, '-')) && l.peekDigit(2) {
+				l.skip(2)
+				l.lexDigits(10)
+				tok = FLOATLITERAL
+			}
 		}
 	}
+
+	lit := l.text(start.Offset)
+	if msg := checkNumber(lit, base, tok); msg != "" {
+		l.errorf(start, "%s", msg)
+		return ILLEGAL, lit
+	}
+	return tok, lit
+}
+
+var baseNames = map[int]string{
+	2:  "binary",
+	8:  "octal",
+	10: "decimal",
+	16: "hexadecimal",
+}
+
+// checkNumber validates a lexed number literal against the integer grammar,
+// returning a description of the first problem found or "" if it is valid.
+func checkNumber(lit string, base int, tok Token) string {
+	digits := lit
+	prefixed := base != 10
+	if prefixed {
+		digits = lit[2:]
+	}
+	if strings.Trim(digits, "_") == "" {
+		return fmt.Sprintf("%s literal has no digits", baseNames[base])
+	}
+
+	// a leading zero on a plain integer is the legacy octal form
+	if tok == INTLITERAL && base == 10 && len(digits) > 1 && digits[0] == '0' {
+		base = 8
+	}
+	if tok == INTLITERAL && base < 10 {
+		for _, r := range digits {
+			if r != '_' && int(r-'0') >= base {
+				return fmt.Sprintf("invalid digit %q in %s literal", r, baseNames[base])
+			}
+		}
+	}
+
+	isDigit := isDecimal
+	if base == 16 {
+		isDigit = isHex
+	}
+	for i, r := range lit {
+		if r != '_' {
+			continue
+		}
+		before := i > 0 && (isDigit(rune(lit[i-1])) || (prefixed && i == 2))
+		after := i+1 < len(lit) && isDigit(rune(lit[i+1]))
+		if !before || !after {
+			return "'_' must separate successive digits"
+		}
+	}
+	return ""
+}
+
+// isLetter reports whether r can start an identifier.
+func isLetter(r rune) bool {
+	if r < utf8.RuneSelf {
+		return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+	}
+	return unicode.IsLetter(r)
+}
+
+func isDecimal(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isHex(r rune) bool {
+	return isDecimal(r) || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+}
+
+// skip consumes n runes that have already been inspected with peekIs.
+func (l *Lexer) skip(n int) {
+	for i := 0; i < n; i++ {
+		l.read()
+	}
+}
+
+// peekIs reports whether the byte n positions ahead of the next one to be read
+// is b.
+func (l *Lexer) peekIs(n int, b byte) bool {
+	return l.off+n < len(l.src) && l.src[l.off+n] == b
+}
+
+// peekDigit reports whether the byte n positions ahead of the next one to be
+// read is an ASCII digit.
+func (l *Lexer) peekDigit(n int) bool {
+	return l.off+n < len(l.src) && isDecimal(rune(l.src[l.off+n]))
 }
 
 func (l *Lexer) lexIdent() string {
-	var lit string
+	start := l.off
 	for {
-		r, _, err := l.reader.ReadRune()
-		if err != nil {
-			if err == io.EOF {
-				return lit
-			}
+		r, ok := l.read()
+		if !ok {
+			return l.text(start)
 		}
 
-		l.pos.col++
-		if unicode.IsLetter(r) {
-			lit = lit + string(r)
-		} else {
+		if !isLetter(r) && !isDecimal(r) && (r < utf8.RuneSelf || !unicode.IsDigit(r)) {
 			l.backup()
-			return lit
+			return l.text(start)
 		}
 	}
 }
 
-func (l *Lexer) lexString() string {
-	var lit string
+// lexString lexes an interpreted string literal whose opening quote has
+// already been read, returning its decoded value. A string left open at a
+// newline or at EOF is reported and returned as ILLEGAL; the newline is left
+// for the next token.
+func (l *Lexer) lexString() (Token, string) {
+	start := l.pos
+	d := decoder{l: l, start: l.off}
 	for {
-		r, _, err := l.reader.ReadRune()
-		if err != nil {
-			if err == io.EOF {
-				return lit
-			}
+		r, ok := l.read()
+		if !ok {
+			l.errorf(start, "string literal not terminated")
+			return ILLEGAL, d.value(l.off)
 		}
 
-		l.pos.col++
-		if r == '"' {
-			return lit
-		} else {
-			lit = lit + string(r)
+		switch r {
+		case '"':
+			return STRINGLITERAL, d.value(l.pos.Offset)
+		case '\n':
+			l.backup()
+			l.errorf(start, "string literal not terminated")
+			return ILLEGAL, d.value(l.off)
+		case '\\':
+			l.lexEscape('"', d.escape())
+		default:
+			d.add()
 		}
 	}
 }
 
-func (l *Lexer) lexCompilerInstruction() string {
-	var lit string
+// decoder builds the value of a literal that may contain escapes. Until the
+// first escape the value is just the source text, so it is only copied into
+// a buffer once an escape needs decoding.
+type decoder struct {
+	l       *Lexer
+	start   int
+	escaped bool
+	val     strings.Builder
+}
+
+// add adds the last rune read to the value.
+func (d *decoder) add() {
+	if d.escaped {
+		d.val.WriteString(d.l.text(d.l.pos.Offset))
+	}
+}
+
+// escape returns the buffer for an escape sequence starting at the last rune
+// read to be decoded into.
+func (d *decoder) escape() *strings.Builder {
+	if !d.escaped {
+		d.val.WriteString(d.l.src[d.start:d.l.pos.Offset])
+		d.escaped = true
+	}
+	return &d.val
+}
+
+// value returns the value of the literal, which ends at end.
+func (d *decoder) value(end int) string {
+	if d.escaped {
+		return d.val.String()
+	}
+	return d.l.src[d.start:end]
+}
+
+// lexRawString lexes a raw string literal whose opening backtick has already
+// been read. Raw strings may span lines and have no escapes; carriage returns
+// are dropped from the value so that it does not depend on line endings.
+func (l *Lexer) lexRawString() (Token, string) {
+	start := l.pos
+	cr := false
 	for {
-		r, _, err := l.reader.ReadRune()
-		if err != nil {
-			if err == io.EOF {
-				return lit
-			}
+		r, ok := l.read()
+		if !ok {
+			l.errorf(start, "raw string literal not terminated")
+			return ILLEGAL, stripCR(l.src[start.Offset+1:l.off], cr)
 		}
 
-		l.pos.col++
-		if r != ' ' {
-			lit = lit + string(r)
-		} else {
-			return lit
+		switch r {
+		case '`':
+			return STRINGLITERAL, stripCR(l.src[start.Offset+1:l.pos.Offset], cr)
+		case '\n':
+			l.resetPosition()
+		case '\r':
+			cr = true
 		}
 	}
 }
 
-func (l *Lexer) lexEquals(r rune) (Token, string) {
-	s := string(r)
-	r, _, _ = l.reader.ReadRune()
-	s = s + string(r)
-	switch r {
-	case '=':
-		return EQUALS, s
+func stripCR(s string, cr bool) string {
+	if !cr {
+		return s
+	}
+	return strings.ReplaceAll(s, "\r", "")
+}
+
+// lexChar lexes a character literal whose opening quote has already been
+// read, returning the decoded character. Literals that do not hold exactly
+// one character, or are left open at a newline or at EOF, are reported and
+// returned as ILLEGAL.
+func (l *Lexer) lexChar() (Token, string) {
+	start := l.pos
+	d := decoder{l: l, start: l.off}
+	n := 0
+	for {
+		r, ok := l.read()
+		if !ok {
+			l.errorf(start, "character literal not terminated")
+			return ILLEGAL, d.value(l.off)
+		}
+
+		switch r {
+		case '\'':
+			switch n {
+			case 0:
+				l.errorf(start, "empty character literal")
+				return ILLEGAL, d.value(l.pos.Offset)
+			case 1:
+				return CHARLITERAL, d.value(l.pos.Offset)
+			default:
+				l.errorf(start, "more than one character in character literal")
+				return ILLEGAL, d.value(l.pos.Offset)
+			}
+		case '\n':
+			l.backup()
+			l.errorf(start, "character literal not terminated")
+			return ILLEGAL, d.value(l.off)
+		case '\\':
+			l.lexEscape('\'', d.escape())
+		default:
+			d.add()
+		}
+		n++
+	}
+}
+
+// simpleEscapes maps the single character escapes to the rune they stand for.
+var simpleEscapes = map[rune]rune{
+	'a':  '\a',
+	'b':  '\b',
+	'f':  '\f',
+	'n':  '\n',
+	'r':  '\r',
+	't':  '\t',
+	'v':  '\v',
+	'\\': '\\',
+}
+
+// lexEscape lexes the rest of an escape sequence after its backslash, writing
+// the decoded value to val. quote is the delimiter of the enclosing literal,
+// which may itself be escaped.
+func (l *Lexer) lexEscape(quote rune, val *strings.Builder) {
+	pos := l.pos
+	r, ok := l.read()
+	if !ok {
+		// the caller reports the unterminated literal
+		return
+	}
+
+	if r == quote {
+		val.WriteRune(r)
+		return
+	}
+	if e, ok := simpleEscapes[r]; ok {
+		val.WriteRune(e)
+		return
+	}
+
+	var n, base int
+	switch {
+	case r == 'x':
+		n, base = 2, 16
+	case r == 'u':
+		n, base = 4, 16
+	case r == 'U':
+		n, base = 8, 16
+	case r >= '0' && r <= '7':
+		n, base = 3, 8
+		l.backup()
+	default:
+		if r == '\n' {
+			l.backup()
+			return
+		}
+		l.errorf(pos, "unknown escape sequence \\%c", r)
+		return
+	}
+
+	var code uint32
+	read := 0
+	for read < n {
+		d, ok := l.read()
+		if !ok {
+			break
+		}
+		v, ok := digitVal(d, base)
+		if !ok {
+			l.backup()
+			break
+		}
+		code = code*uint32(base) + v
+		read++
+	}
+	// the escape as written, without its backslash
+	lit := l.src[pos.Offset+1 : l.off]
+	if read < n {
+		l.errorf(pos, "escape sequence \\%s is incomplete", lit)
+		return
+	}
+
+	switch {
+	case r == 'x' || base == 8:
+		if code > 255 {
+			l.errorf(pos, "octal escape value \\%s > 255", lit)
+			return
+		}
+		// \x and octal escapes stand for single bytes
+		val.WriteByte(byte(code))
+	case code > unicode.MaxRune || (code >= 0xD800 && code < 0xE000):
+		l.errorf(pos, "escape sequence \\%s is an invalid Unicode code point", lit)
 	default:
-		return ASSIGN, s
+		val.WriteRune(rune(code))
 	}
 }
 
-func (l *Lexer) lexBang(r rune) (Token, string) {
-	s := string(r)
-	r, _, _ = l.reader.ReadRune()
-	s = s + string(r)
-	switch r {
+func digitVal(r rune, base int) (uint32, bool) {
+	switch {
+	case isDecimal(r) && int(r-'0') < base:
+		return uint32(r - '0'), true
+	case base == 16 && r >= 'a' && r <= 'f':
+		return uint32(r-'a') + 10, true
+	case base == 16 && r >= 'A' && r <= 'F':
+		return uint32(r-'A') + 10, true
+	}
+	return 0, false
+}
+
+// lexCompilerInstruction lexes a directive whose '@' has already been read,
+// returning its token from the directives registry and its name. Unknown
+// directives are reported and returned as ILLEGAL.
+func (l *Lexer) lexCompilerInstruction(start Position) (Token, string) {
+	name := l.lexIdent()
+	if name == "" {
+		l.errorf(start, "expected directive name after @")
+		return ILLEGAL, "@"
+	}
+	tok, ok := directives[name]
+	if !ok {
+		l.errorf(start, "unknown directive @%s", name)
+		return ILLEGAL, l.text(start.Offset)
+	}
+	return tok, name
+}
+
+// lexOperator lexes an operator starting with r, which is either one of the
+// two character operators or single on its own.
+func (l *Lexer) lexOperator(r rune, single Token) (Token, string) {
+	start := l.pos.Offset
+	if _, ok := l.read(); !ok {
+		return single, l.text(start)
+	}
+
+	if tok, ok := operators[l.text(start)]; ok {
+		return tok, l.text(start)
+	}
+	l.backup()
+	return single, l.text(start)
+}
+
+// lexDot lexes a selector dot or one of the range operators .. and ..=, which
+// are exclusive and inclusive of their end.
+func (l *Lexer) lexDot() (Token, string) {
+	start := l.pos.Offset
+	if !l.peekIs(0, '.') {
+		return DOT, l.text(start)
+	}
+	l.skip(1)
+	if l.peekIs(0, '=') {
+		l.skip(1)
+		return RANGEINCL, l.text(start)
+	}
+	return RANGE, l.text(start)
+}
+
+// lexSlash lexes a division operator or a comment. Line comments stop before
+// the newline that ends them so that it can still end a statement. Comments
+// starting with exactly three slashes are doc comments, whose value is the
+// text after the slashes.
+func (l *Lexer) lexSlash(r rune) (Token, string) {
+	start := l.pos
+	// like a newline, a comment running over lines can end a statement,
+	// which comes before the comment
+	if l.insertSemi && l.peekIs(0, '*') && l.blockCommentSpansLines(start.Offset) {
+		l.backup()
+		return SEMICOLON, "\n"
+	}
+
+	next, ok := l.read()
+	if !ok {
+		return DIV, l.text(start.Offset)
+	}
+
+	switch next {
+	case '/':
+		l.lexLineComment()
+		lit := l.text(start.Offset)
+		if strings.HasPrefix(lit, "///") && !strings.HasPrefix(lit, "////") {
+			doc := strings.TrimPrefix(lit, "///")
+			doc = strings.TrimPrefix(doc, " ")
+			return DOCCOMMENT, doc
+		}
+		return COMMENT, lit
+	case '*':
+		ok := l.lexBlockComment()
+		if !ok {
+			l.errorf(start, "comment not terminated")
+			return ILLEGAL, l.text(start.Offset)
+		}
+		return COMMENT, l.text(start.Offset)
 	case '=':
-		return NOTEQUALS, s
+		return DIVASSIGN, l.text(start.Offset)
 	default:
-		return NOT, s
+		l.backup()
+		return DIV, l.text(start.Offset)
 	}
 }
 
-func (l *Lexer) lexSlash(r string) (Token, string) {
-	lit := r
+func (l *Lexer) lexLineComment() {
 	for {
-		r, _, _ := l.reader.ReadRune()
-		if r == '/' {
-			l.pos.col++
-			// the rest of the line is a comment and should be ignored
-			r, _, _ = l.reader.ReadRune()
-			for r != '\n' {
-				r, _, _ = l.reader.ReadRune()
+		r, ok := l.read()
+		if !ok {
+			return
+		}
+
+		if r == '\n' {
+			l.backup()
+			return
+		}
+	}
+}
+
+// blockCommentSpansLines reports whether the block comment starting at off
+// runs over more than one line before it is closed or the source ends.
+func (l *Lexer) blockCommentSpansLines(off int) bool {
+	depth := 0
+	for i := off; i < len(l.src); i++ {
+		switch {
+		case l.src[i] == '\n':
+			return true
+		case i+1 == len(l.src):
+			return false
+		case l.src[i] == '/' && l.src[i+1] == '*':
+			depth++
+			i++
+		case l.src[i] == '*' && l.src[i+1] == '/':
+			depth--
+			i++
+			if depth == 0 {
+				return false
 			}
+		}
+	}
+	return false
+}
+
+// lexBlockComment lexes a block comment whose opening "/*" has already been
+// read, reporting whether it was closed. Block comments nest, so the comment
+// only ends once every "/*" inside it has been closed.
+func (l *Lexer) lexBlockComment() bool {
+	depth := 1
+	var prev rune
+	for {
+		r, ok := l.read()
+		if !ok {
+			return false
+		}
+
+		switch {
+		case r == '\n':
 			l.resetPosition()
-			return NEWLINE, "\n"
-		} else {
-			l.backup()
-			return DIV, lit
+		case prev == '/' && r == '*':
+			depth++
+			// the '*' cannot also close the comment it opened
+			r = 0
+		case prev == '*' && r == '/':
+			depth--
+			if depth == 0 {
+				return true
+			}
+			r = 0
 		}
+		prev = r
 	}
 }
@@ -0,0 +1,43 @@
+package lex
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// synthetic returns a source of at least lines lines covering the lexer's
+// main paths: keywords, identifiers, numbers, strings, operators and comments.
+func synthetic(lines int) string {
+	var b strings.Builder
+	// each function is 12 lines long
+	for i := 0; i*12 < lines; i++ {
+		fmt.Fprintf(&b, "/// f%d computes something\n", i)
+		fmt.Fprintf(&b, "func f%d(int x, []string names) int {\n", i)
+		fmt.Fprintf(&b, "    var float y = %d.5e-3 * x + 0x%x // scale\n", i, i)
+		b.WriteString("    var string s = \"name: \\t\" + names[0]\n")
+		b.WriteString("    var char c = '\\n'\n")
+		b.WriteString("    /* a block\n       comment */\n")
+		b.WriteString("    for i in 0..=x {\n")
+		b.WriteString("        if (i % 2 == 0 && y >= 1.0) { y += i } else { y -= 1 }\n")
+		b.WriteString("    }\n")
+		b.WriteString("    return y\n")
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+func BenchmarkLex(b *testing.B) {
+	src := []byte(synthetic(300000))
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l := NewLexerBytes(src)
+		for {
+			if tok := l.Lex(); tok.Tok == EOF {
+				break
+			}
+		}
+	}
+}
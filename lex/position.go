@@ -0,0 +1,140 @@
+package lex
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Position is a location in a source file. Line and Column start at 1, and
+// Column counts bytes rather than runes. Offset is the byte offset into the
+// file, starting at 0.
+type Position struct {
+	Filename string
+	Line     int
+	Column   int
+	Offset   int
+}
+
+// IsValid reports whether the position has a line number.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
+// Pos is a compact source position, identifying a byte offset in one of the
+// files of a FileSet. The zero Pos is NoPos and belongs to no file.
+type Pos int
+
+const NoPos Pos = 0
+
+// File holds the line table of a file added to a FileSet, which maps byte
+// offsets in the file back to lines and columns.
+type File struct {
+	name  string
+	base  int
+	size  int
+	lines []int
+}
+
+func (f *File) Name() string {
+	return f.name
+}
+
+// Base returns the Pos of the first byte of the file.
+func (f *File) Base() int {
+	return f.base
+}
+
+func (f *File) Size() int {
+	return f.size
+}
+
+// AddLine records that a line starts at offset. Offsets must be added in
+// increasing order; anything out of order or past the end of the file is
+// ignored. A line can start at the end of the file, after a final newline,
+// which is where the lexer puts EOF.
+func (f *File) AddLine(offset int) {
+	if offset <= f.lines[len(f.lines)-1] || offset > f.size {
+		return
+	}
+	f.lines = append(f.lines, offset)
+}
+
+// Pos returns the Pos of offset in the file.
+func (f *File) Pos(offset int) Pos {
+	if offset > f.size {
+		offset = f.size
+	}
+	return Pos(f.base + offset)
+}
+
+// Offset returns the offset in the file of p, which must belong to f.
+func (f *File) Offset(p Pos) int {
+	return int(p) - f.base
+}
+
+// Position returns the position of offset in the file.
+func (f *File) Position(offset int) Position {
+	if offset > f.size {
+		offset = f.size
+	}
+	line := sort.Search(len(f.lines), func(i int) bool { return f.lines[i] > offset })
+	return Position{
+		Filename: f.name,
+		Line:     line,
+		Column:   offset - f.lines[line-1] + 1,
+		Offset:   offset,
+	}
+}
+
+// FileSet assigns each of the files in a compilation a distinct range of Pos
+// values, so that a single Pos identifies both a file and an offset in it.
+type FileSet struct {
+	base  int
+	files []*File
+}
+
+func NewFileSet() *FileSet {
+	return &FileSet{base: 1}
+}
+
+// AddFile adds a file of the given size to the set. Its Pos values start
+// after those of every file added before it.
+func (s *FileSet) AddFile(filename string, size int) *File {
+	f := &File{name: filename, base: s.base, size: size, lines: []int{0}}
+	// the extra byte gives the end of file a Pos of its own
+	s.base += size + 1
+	s.files = append(s.files, f)
+	return f
+}
+
+// File returns the file containing p, or nil if there is none.
+func (s *FileSet) File(p Pos) *File {
+	i := sort.Search(len(s.files), func(i int) bool { return s.files[i].base > int(p) }) - 1
+	if i < 0 || int(p) > s.files[i].base+s.files[i].size {
+		return nil
+	}
+	return s.files[i]
+}
+
+// Position returns the position of p, or the zero Position if p does not
+// belong to any file in the set.
+func (s *FileSet) Position(p Pos) Position {
+	if f := s.File(p); f != nil {
+		return f.Position(f.Offset(p))
+	}
+	return Position{}
+}
@@ -0,0 +1,29 @@
+package lex
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestFilePositions checks that a file's line table gives every token,
+// EOF included, the position the lexer gave it.
+func TestFilePositions(t *testing.T) {
+	tests := []string{
+		"",
+		"x",
+		"x\n",
+		"x\r\n\r\n",
+		"var int x = 1\n/* spans\nlines */ y\n",
+		"var string s = `a\nb`\n\n",
+	}
+	for _, src := range tests {
+		fset := NewFileSet()
+		file := fset.AddFile("a.mn", len(src))
+		tokens, _ := TokenizeFile(file, strings.NewReader(src))
+		for _, tok := range tokens {
+			if got := fset.Position(file.Pos(tok.Pos.Offset)); got != tok.Pos {
+				t.Errorf("%q: %s at %s is at %s in the file set", src, tok.Tok, tok.Pos, got)
+			}
+		}
+	}
+}
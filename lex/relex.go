@@ -0,0 +1,113 @@
+package lex
+
+// Edit is a change to source text: Delete bytes starting at Offset are
+// replaced by Insert.
+type Edit struct {
+	Offset int
+	Delete int
+	Insert string
+}
+
+// Apply returns src with the edit made to it. The deleted range must lie
+// within src.
+func (e Edit) Apply(src string) string {
+	return src[:e.Offset] + e.Insert + src[e.Offset+e.Delete:]
+}
+
+// Relex updates tokens, the result of lexing src with mode, for edit being
+// made to src. Only the region around the edit is lexed again: tokens up to
+// the start of the line the edit begins on are kept as they are, and lexing
+// stops at the first token boundary after the edit where the lexer is in the
+// same state it was in before, from which point the old tokens are reused
+// with their positions moved. The diagnostics returned are those reported
+// for the tokens that were lexed again.
+//
+// Positions carry the filename of the old tokens, but no File line table is
+// updated.
+func Relex(src string, tokens []LexedTok, edit Edit, mode Mode) ([]LexedTok, []Diagnostic) {
+	newSrc := edit.Apply(src)
+	editEnd := edit.Offset + edit.Delete
+	delta := len(edit.Insert) - edit.Delete
+
+	// No lookahead crosses a newline, so lexing can restart just past any
+	// newline before the edit that ended a statement, in the state the
+	// lexer starts a file in.
+	restart := 0
+	for i, tok := range tokens {
+		if tok.End.Offset > edit.Offset {
+			break
+		}
+		if endsLine(tok) {
+			restart = i + 1
+		}
+	}
+
+	l := newLexer(newSrc)
+	l.mode = mode
+	if len(tokens) > 0 {
+		l.pos.Filename = tokens[0].Pos.Filename
+	}
+	if restart > 0 {
+		end := tokens[restart-1].End
+		l.pos.Line = end.Line
+		l.off = end.Offset
+		l.lineStart = end.Offset
+		l.triviaStart = end.Offset
+	}
+
+	// room for the old tokens and a few more made by the edit, so the
+	// result is usually built without growing
+	result := make([]LexedTok, restart, len(tokens)+len(edit.Insert)+16)
+	copy(result, tokens[:restart])
+	old := restart
+	for {
+		tok := l.Lex()
+		result = append(result, tok)
+		if tok.Tok == EOF {
+			return result, l.Errors()
+		}
+
+		// the old token ending in the same place with the same kind
+		// leaves the lexer in the same state, and everything after it
+		// was lexed from the same text. Comments leave whether a newline
+		// ends a statement to the token before them, so they can't be
+		// compared on their own.
+		if tok.Tok == COMMENT || tok.Tok == DOCCOMMENT {
+			continue
+		}
+		for old < len(tokens) && oldEnd(tokens[old]) < editEnd {
+			old++
+		}
+		for old < len(tokens) && oldEnd(tokens[old])+delta < l.off {
+			old++
+		}
+		if old < len(tokens)-1 && oldEnd(tokens[old])+delta == l.off && tokens[old].Tok == tok.Tok {
+			line := tokens[old].End.Line
+			n := len(result)
+			result = append(result, tokens[old+1:]...)
+			for i := n; i < len(result); i++ {
+				t := &result[i]
+				t.Pos = l.shift(t.Pos, line, delta)
+				t.End = l.shift(t.End, line, delta)
+			}
+			return result, l.Errors()
+		}
+	}
+}
+
+// oldEnd returns the offset the lexer had reached once it returned tok.
+func oldEnd(tok LexedTok) int {
+	return tok.End.Offset + len(tok.Trailing)
+}
+
+// shift moves pos, from the old tokens passed to Relex, by delta bytes. line
+// is the old line the lexer is on, which continues on the lexer's current
+// line, so positions on it have their columns worked out again.
+func (l *Lexer) shift(pos Position, line, delta int) Position {
+	pos.Offset += delta
+	if pos.Line == line {
+		pos.Column = pos.Offset - l.lineStart + 1
+	}
+	pos.Line += l.pos.Line - line
+	return pos
+}
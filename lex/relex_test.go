@@ -0,0 +1,98 @@
+package lex
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fragments are pieces of source that edits and generated sources are built
+// from, chosen to land on the boundaries the lexer has to get right.
+var fragments = []string{
+	"\n", "\r\n", " ", "\t", "x", "_", "é", "\xff", "1", "5", ".", "..", "..=", "e", "+", "=", "&", "|",
+	"/", "*", "//", "///", "/*", "*/", "\"", "`", "'", "\\", "0x", "1e+5", "@", "@import", "return", ";", "}",
+}
+
+func randomFragments(r *rand.Rand, n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteString(fragments[r.Intn(len(fragments))])
+	}
+	return b.String()
+}
+
+// checkRelex checks that relexing src's tokens for edit gives the same tokens
+// as lexing the edited source from scratch.
+func checkRelex(t *testing.T, src string, edit Edit, mode Mode) {
+	t.Helper()
+	got, _ := Relex(src, lexAll(src, mode), edit, mode)
+	want := lexAll(edit.Apply(src), mode)
+	if reflect.DeepEqual(got, want) {
+		return
+	}
+	for i := range want {
+		if i >= len(got) || !reflect.DeepEqual(got[i], want[i]) {
+			if i < len(got) {
+				t.Fatalf("mode %d: relexing %q for %+v: token %d is %+v, want %+v", mode, src, edit, i, got[i], want[i])
+			}
+			t.Fatalf("mode %d: relexing %q for %+v: token %d is missing, want %+v", mode, src, edit, i, want[i])
+		}
+	}
+	t.Fatalf("mode %d: relexing %q for %+v: got %d tokens, want %d", mode, src, edit, len(got), len(want))
+}
+
+// TestRelexEverywhere makes edits at every offset of sources built around
+// multi-line tokens and CRLF line endings.
+func TestRelexEverywhere(t *testing.T) {
+	sources := []string{
+		"var string s = `raw\nstring\n` + x\ny = 1\n",
+		"x /* outer /* inner\n */ still\n comment */ y\nz\n",
+		"var int x = 1\r\nx += 2\r\n\r\nf(x)\r\n",
+		"/// doc\nfunc f(int a) int {\n  return a // done\n}\n",
+		"x = 1.5e+3 + 0..=4 && 'c' != \"s\\n\"\n",
+	}
+	inserts := []string{"", "\n", "`", "/*", "*/", "\r\n", "x", "1"}
+	for _, src := range sources {
+		for off := 0; off <= len(src); off++ {
+			for del := 0; del <= 2 && off+del <= len(src); del++ {
+				for _, ins := range inserts {
+					edit := Edit{Offset: off, Delete: del, Insert: ins}
+					checkRelex(t, src, edit, 0)
+					checkRelex(t, src, edit, PreserveTrivia)
+				}
+			}
+		}
+	}
+}
+
+func TestRelexRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		src := randomFragments(r, r.Intn(40))
+		off := r.Intn(len(src) + 1)
+		del := 0
+		if off < len(src) {
+			del = r.Intn(len(src) - off + 1)
+			if del > 6 {
+				del = 6
+			}
+		}
+		edit := Edit{Offset: off, Delete: del, Insert: randomFragments(r, r.Intn(3))}
+		checkRelex(t, src, edit, 0)
+		checkRelex(t, src, edit, PreserveTrivia)
+	}
+}
+
+// BenchmarkRelex makes a one-byte edit near the start of a large source,
+// which should cost far less than lexing it again.
+func BenchmarkRelex(b *testing.B) {
+	src := synthetic(100000)
+	tokens := lexAll(src, 0)
+	edit := Edit{Offset: len(src) / 100, Insert: "x"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Relex(src, tokens, edit, 0)
+	}
+}
@@ -21,15 +21,25 @@ const (
 	BREAK
 	CONTINUE
 	AS
+	IN
 	// end of language keywords
 	TYPEANNOT
 	IMPORT
+	DIRECTIVE
 	ASSIGN
+	ADDASSIGN
+	SUBASSIGN
+	MULASSIGN
+	DIVASSIGN
+	MODASSIGN
 	ADD
 	MUL
 	SUB
 	DIV
 	MOD
+	INC
+	DEC
+	ARROW
 	LPAREN
 	RPAREN
 	LSQRBRAC
@@ -37,18 +47,28 @@ const (
 	BLOCKSTART
 	BLOCKEND
 	INTLITERAL
+	FLOATLITERAL
 	STRINGLITERAL
+	CHARLITERAL
 	DOT
-	NEWLINE
+	RANGE
+	RANGEINCL
+	SEMICOLON
+	COMMENT
+	DOCCOMMENT
 	AND
+	OR
 	NOT
 	GT
 	LT
+	GTE
+	LTE
 	TRUE
 	FALSE
 	NOTEQUALS
 	EQUALS
 	COMMA
+	COLON
 )
 
 var tokens = []string{
@@ -67,14 +87,24 @@ var tokens = []string{
 	BREAK:         "BREAK",
 	CONTINUE:      "CONTINUE",
 	AS:            "AS",
+	IN:            "IN",
 	TYPEANNOT:     "TYPEANNOT",
-	IMPORT:        "IMPORT", // right now import just exists, has no functionality yet
+	IMPORT:        "IMPORT",
+	DIRECTIVE:     "DIRECTIVE",
 	ASSIGN:        "ASSIGN",
+	ADDASSIGN:     "ADDASSIGN",
+	SUBASSIGN:     "SUBASSIGN",
+	MULASSIGN:     "MULASSIGN",
+	DIVASSIGN:     "DIVASSIGN",
+	MODASSIGN:     "MODASSIGN",
 	ADD:           "ADD",
 	MUL:           "MUL",
 	SUB:           "SUB",
 	DIV:           "DIV",
 	MOD:           "MOD",
+	INC:           "INC",
+	DEC:           "DEC",
+	ARROW:         "ARROW",
 	LPAREN:        "LPAREN",
 	RPAREN:        "RPAREN",
 	LSQRBRAC:      "LSQRBRAC",
@@ -82,33 +112,28 @@ var tokens = []string{
 	BLOCKSTART:    "BLOCKSTART",
 	BLOCKEND:      "BLOCKEND",
 	STRINGLITERAL: "STRINGLITERAL",
+	CHARLITERAL:   "CHARLITERAL",
 	INTLITERAL:    "INTLITERAL",
+	FLOATLITERAL:  "FLOATLITERAL",
 	DOT:           "DOT",
-	NEWLINE:       "NEWLINE",
+	RANGE:         "RANGE",
+	RANGEINCL:     "RANGEINCL",
+	SEMICOLON:     "SEMICOLON",
+	COMMENT:       "COMMENT",
+	DOCCOMMENT:    "DOCCOMMENT",
 	AND:           "AND",
+	OR:            "OR",
 	NOT:           "NOT",
 	GT:            "GT",
 	LT:            "LT",
+	GTE:           "GTE",
+	LTE:           "LTE",
 	TRUE:          "TRUE",
 	FALSE:         "FALSE",
 	NOTEQUALS:     "NOTEQUALS",
 	EQUALS:        "EQUALS",
 	COMMA:         "COMMA",
-}
-
-var keywords = []string{
-	"efunc",
-	"func",
-	"meth",
-	"var",
-	"if",
-	"else",
-	"for",
-	"while",
-	"return",
-	"break",
-	"continue",
-	"as",
+	COLON:         "COLON",
 }
 
 var kwmap = map[string]Token{
@@ -124,14 +149,71 @@ var kwmap = map[string]Token{
 	"break":    BREAK,
 	"continue": CONTINUE,
 	"as":       AS,
+	"in":       IN,
+	"true":     TRUE,
+	"false":    FALSE,
+}
+
+// directives maps the name of each compiler directive to its token. @import
+// declares a dependency and has a token of its own; the rest are pragmas that
+// apply to the declaration after them.
+var directives = map[string]Token{
+	"import":     IMPORT,
+	"extern":     DIRECTIVE,
+	"inline":     DIRECTIVE,
+	"test":       DIRECTIVE,
+	"deprecated": DIRECTIVE,
+	"version":    DIRECTIVE,
+}
+
+// operators maps each two character operator to its token. Operators whose
+// first character is not an operator on its own are lexed as ILLEGAL when the
+// second character does not follow.
+var operators = map[string]Token{
+	"==": EQUALS,
+	"!=": NOTEQUALS,
+	"<=": LTE,
+	">=": GTE,
+	"&&": AND,
+	"||": OR,
+	"+=": ADDASSIGN,
+	"-=": SUBASSIGN,
+	"*=": MULASSIGN,
+	"/=": DIVASSIGN,
+	"%=": MODASSIGN,
+	"++": INC,
+	"--": DEC,
+	"->": ARROW,
+}
+
+// endsStatement holds the tokens after which a newline, or the end of the
+// input, ends a statement. Anywhere else a newline is only whitespace, so an
+// expression can carry on over several lines as long as each line but the
+// last ends with an operator or an opening bracket.
+var endsStatement = map[Token]bool{
+	IDENT:         true,
+	INTLITERAL:    true,
+	FLOATLITERAL:  true,
+	STRINGLITERAL: true,
+	CHARLITERAL:   true,
+	TRUE:          true,
+	FALSE:         true,
+	RPAREN:        true,
+	RSQRBRAC:      true,
+	BLOCKEND:      true,
+	RETURN:        true,
+	BREAK:         true,
+	CONTINUE:      true,
 }
 
-var types = []string{
-	"string",
-	"int",
-	"float",
-	"double",
-	"bool",
+// types holds the names of the built-in type annotations
+var types = map[string]bool{
+	"string": true,
+	"int":    true,
+	"float":  true,
+	"double": true,
+	"bool":   true,
+	"char":   true,
 }
 
 func (t Token) String() string {
@@ -140,8 +222,22 @@ func (t Token) String() string {
 
 type LexedTok struct {
 	Pos Position
+	// End is the position just past the last character of the token.
+	End Position
 	Tok Token
+	// Val is the value of the token. For most tokens this is the text as
+	// written, but string literals hold their decoded contents.
 	Val string
+	// Raw is the source text of the token, exactly as written.
+	Raw string
+	// Leading and Trailing hold the trivia around the token when lexing
+	// with PreserveTrivia. Trailing trivia runs up to the end of the line
+	// the token ends on, stopping before any block comment that runs onto
+	// later lines, and everything else before the next token leads it, so
+	// that concatenating Leading, Raw and Trailing for every token up to
+	// EOF reproduces the source.
+	Leading  string
+	Trailing string
 }
 
 func NewLexedTok(pos Position, tok Token, val string) LexedTok {
@@ -149,6 +245,7 @@ func NewLexedTok(pos Position, tok Token, val string) LexedTok {
 		Pos: pos,
 		Tok: tok,
 		Val: val,
+		Raw: val,
 	}
 }
 
@@ -158,7 +255,9 @@ func (lt *LexedTok) String() string {
 
 var datatypes = map[Token]string{
 	INTLITERAL:    "int",
+	FLOATLITERAL:  "float",
 	STRINGLITERAL: "string",
+	CHARLITERAL:   "char",
 	TRUE:          "bool",
 	FALSE:         "bool",
 }
@@ -0,0 +1,41 @@
+package lex
+
+import "io"
+
+// Tokenize lexes all of r, returning its tokens up to and including EOF along
+// with the diagnostics reported while lexing them.
+func Tokenize(r io.Reader) ([]LexedTok, []Diagnostic) {
+	return NewLexer(r).tokenize()
+}
+
+// TokenizeFile is like Tokenize, but positions refer to file and its line
+// table is filled in.
+func TokenizeFile(file *File, r io.Reader) ([]LexedTok, []Diagnostic) {
+	return NewFileLexer(file, r).tokenize()
+}
+
+func (l *Lexer) tokenize() ([]LexedTok, []Diagnostic) {
+	var tokens []LexedTok
+	for {
+		tok, ok := l.Next()
+		if !ok {
+			return tokens, l.Errors()
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+// Next returns the next token, or false once the EOF token has already been
+// returned. Unlike collecting the tokens up front, pulling them one at a time
+// means they never all have to be held in memory at once. The source itself
+// always is, as described on NewLexer.
+func (l *Lexer) Next() (LexedTok, bool) {
+	if l.done {
+		return LexedTok{}, false
+	}
+	tok := l.Lex()
+	if tok.Tok == EOF {
+		l.done = true
+	}
+	return tok, true
+}
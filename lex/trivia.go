@@ -0,0 +1,36 @@
+package lex
+
+import (
+	"strings"
+	"unicode"
+)
+
+// lexTrailingTrivia lexes the spaces and comments after a token up to the end
+// of its line, returning them as written. Doc comments are left to be lexed as
+// tokens, and block comments that run onto later lines to lead the next token.
+func (l *Lexer) lexTrailingTrivia() string {
+	start := l.off
+	for {
+		if l.peekIs(0, '/') && ((l.peekIs(1, '*') && !l.blockCommentSpansLines(l.off)) || (l.peekIs(1, '/') && !l.peekDocComment())) {
+			r, _ := l.read()
+			l.lexSlash(r)
+			continue
+		}
+
+		r, ok := l.read()
+		if !ok {
+			break
+		}
+		if r == '\n' || !unicode.IsSpace(r) {
+			l.backup()
+			break
+		}
+	}
+	return l.text(start)
+}
+
+// peekDocComment reports whether the input continues with a doc comment.
+func (l *Lexer) peekDocComment() bool {
+	rest := l.src[l.off:]
+	return strings.HasPrefix(rest, "///") && !strings.HasPrefix(rest, "////")
+}
@@ -0,0 +1,61 @@
+package lex
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// lexAll lexes src with mode, returning every token up to and including EOF.
+func lexAll(src string, mode Mode) []LexedTok {
+	l := NewLexerBytes([]byte(src))
+	l.SetMode(mode)
+	var tokens []LexedTok
+	for {
+		tok, ok := l.Next()
+		if !ok {
+			return tokens
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+func TestTriviaRoundTrip(t *testing.T) {
+	tests := map[string]string{
+		"empty":               "",
+		"crlf":                "var int x = 1\r\nvar int y = x +\r\n\t2\r\n\r\nf(x, y)\r\n",
+		"trailing comment":    "var int x = 1 // one\nx += 1 /* inline */ // two\n",
+		"block comment":       "x /* spans\nlines */ y\n/* a /* nested\n*/ comment */\nz",
+		"doc comments":        "/// doc\n/// more\nfunc f() {}\n",
+		"no final newline":    "func f() {\n  return 1\n}",
+		"blank lines":         "\n\n\nx\n\n\n\ny\n\n",
+		"unterminated":        "x /* never\nclosed",
+		"raw string":          "var string s = `a\r\nb`\n",
+		"trailing whitespace": "x   \t\n  ",
+	}
+
+	samples, err := filepath.Glob("../molybdenum/*.mn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range samples {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests[filepath.Base(name)] = string(src)
+	}
+
+	for name, src := range tests {
+		var b strings.Builder
+		for _, tok := range lexAll(src, PreserveTrivia) {
+			b.WriteString(tok.Leading)
+			b.WriteString(tok.Raw)
+			b.WriteString(tok.Trailing)
+		}
+		if got := b.String(); got != src {
+			t.Errorf("%s: tokens rebuild %q, want %q", name, got, src)
+		}
+	}
+}
@@ -18,19 +18,21 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	lexer := lex.NewLexer(reader)
-	var tokens []lex.LexedTok
-	pos, tok, val := lexer.Lex()
-	tokens = append(tokens, lex.NewLexedTok(pos, tok, val))
-	for tok != lex.EOF {
-		pos, tok, val = lexer.Lex()
-		tokens = append(tokens, lex.NewLexedTok(pos, tok, val))
+	info, err := reader.Stat()
+	if err != nil {
+		panic(err)
 	}
+	fset := lex.NewFileSet()
+	tokens, diags := lex.TokenizeFile(fset.AddFile(reader.Name(), int(info.Size())), reader)
 
 	for _, tok := range tokens {
 		fmt.Println(tok)
 	}
 
+	for _, err := range diags {
+		fmt.Println(err)
+	}
+
 	p := parse.New(tokens)
 	ast := p.Parse()
 	fmt.Printf("Errors: %s\n", p.Errors())
@@ -3,23 +3,53 @@ package parse
 import (
 	"fmt"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/westsi/molybdenum/ast"
 	"github.com/westsi/molybdenum/lex"
 )
 
 type Parser struct {
-	pr *ParseReader
+	pr tokenReader
 
 	errors []string
 
 	curTok  lex.LexedTok
 	peekTok lex.LexedTok
 
+	// doc comments are collected here until the token they document is read
+	doc     []string
+	curDoc  string
+	peekDoc string
+	// lastLine is the line the last token read ends on, and docLine the
+	// line the last doc comment collected ends on
+	lastLine int
+	docLine  int
+
+	// pastImports is set once anything but an import has been parsed, after
+	// which imports are no longer allowed
+	pastImports bool
+
+	// loops is the number of loops around the statement being parsed, and
+	// labels holds the labels of those that have them
+	loops  int
+	labels []string
+
 	prefixParseFuncs map[lex.Token]prefixParseFunc
 	infixParseFuncs  map[lex.Token]infixParseFunc
 }
 
+// assignOperators holds the tokens that make a statement an assignment.
+var assignOperators = map[lex.Token]bool{
+	lex.ASSIGN:    true,
+	lex.ADDASSIGN: true,
+	lex.SUBASSIGN: true,
+	lex.MULASSIGN: true,
+	lex.DIVASSIGN: true,
+	lex.MODASSIGN: true,
+}
+
 type (
 	prefixParseFunc func() ast.Expression
 	infixParseFunc  func(ast.Expression) ast.Expression
@@ -31,12 +61,21 @@ func (p *Parser) registerPrefix(tokenType lex.Token, fn prefixParseFunc) {
 func (p *Parser) registerInfix(tokenType lex.Token, fn infixParseFunc) {
 	p.infixParseFuncs[tokenType] = fn
 }
-func (p *Parser) noPrefixParseFuncError(t lex.Token) {
-	p.errors = append(p.errors, fmt.Sprintf("no prefix parse function for %s found", t))
+func (p *Parser) noPrefixParseFuncError(t lex.LexedTok) {
+	p.errorf(t.Pos, "no prefix parse function for %s found", t.Tok)
 }
 
 func New(tokens []lex.LexedTok) *Parser {
-	pr := NewParseReader(tokens)
+	return newParser(NewParseReader(tokens))
+}
+
+// NewFromLexer returns a parser that pulls tokens from l as it needs them,
+// rather than from a slice lexed up front.
+func NewFromLexer(l *lex.Lexer) *Parser {
+	return newParser(&lexReader{l: l})
+}
+
+func newParser(pr tokenReader) *Parser {
 	p := &Parser{pr: pr, errors: []string{}}
 	p.nextTok()
 	p.nextTok()
@@ -44,11 +83,15 @@ func New(tokens []lex.LexedTok) *Parser {
 	p.prefixParseFuncs = make(map[lex.Token]prefixParseFunc)
 	p.registerPrefix(lex.IDENT, p.parseIdentifier)
 	p.registerPrefix(lex.INTLITERAL, p.parseIntegerLiteral)
+	p.registerPrefix(lex.FLOATLITERAL, p.parseFloatLiteral)
+	p.registerPrefix(lex.STRINGLITERAL, p.parseStringLiteral)
+	p.registerPrefix(lex.CHARLITERAL, p.parseCharLiteral)
 	p.registerPrefix(lex.NOT, p.parsePrefixExpression)
 	p.registerPrefix(lex.SUB, p.parsePrefixExpression)
 	p.registerPrefix(lex.TRUE, p.parseBoolean)
 	p.registerPrefix(lex.FALSE, p.parseBoolean)
 	p.registerPrefix(lex.IF, p.parseIfExpression)
+	p.registerPrefix(lex.LPAREN, p.parseGroupedExpression)
 	// p.registerPrefix(lex.FUNC, p.parseFunctionDefinition)
 	// p.registerPrefix(lex.EFUNC, p.parseEntrypointFunctionDefinition)
 	p.infixParseFuncs = make(map[lex.Token]infixParseFunc)
@@ -56,18 +99,67 @@ func New(tokens []lex.LexedTok) *Parser {
 	p.registerInfix(lex.SUB, p.parseInfixExpression)
 	p.registerInfix(lex.MUL, p.parseInfixExpression)
 	p.registerInfix(lex.DIV, p.parseInfixExpression)
+	p.registerInfix(lex.MOD, p.parseInfixExpression)
 	p.registerInfix(lex.EQUALS, p.parseInfixExpression)
 	p.registerInfix(lex.NOTEQUALS, p.parseInfixExpression)
 	p.registerInfix(lex.LT, p.parseInfixExpression)
 	p.registerInfix(lex.GT, p.parseInfixExpression)
+	p.registerInfix(lex.LTE, p.parseInfixExpression)
+	p.registerInfix(lex.GTE, p.parseInfixExpression)
+	p.registerInfix(lex.AND, p.parseInfixExpression)
+	p.registerInfix(lex.OR, p.parseInfixExpression)
+	p.registerInfix(lex.RANGE, p.parseRangeExpression)
+	p.registerInfix(lex.RANGEINCL, p.parseRangeExpression)
 	p.registerInfix(lex.LPAREN, p.parseCallExpression)
+	p.registerInfix(lex.LSQRBRAC, p.parseIndexExpression)
+	p.registerInfix(lex.DOT, p.parseSelectorExpression)
 	return p
 }
 
 func (p *Parser) nextTok() {
 	p.curTok = p.peekTok
+	p.curDoc = p.peekDoc
 	pt := p.pr.Read()
+	for pt.Tok == lex.COMMENT || pt.Tok == lex.DOCCOMMENT {
+		// only doc comments on lines of their own document what follows
+		// them, and one after a token ends any run before it, as does a
+		// blank line
+		if pt.Tok == lex.DOCCOMMENT {
+			if pt.Pos.Line <= p.lastLine {
+				p.doc = nil
+			} else {
+				if pt.Pos.Line > p.docLine+1 {
+					p.doc = nil
+				}
+				p.doc = append(p.doc, pt.Val)
+				p.docLine = endLine(pt)
+			}
+		}
+		p.lastLine = endLine(pt)
+		pt = p.pr.Read()
+	}
+	p.lastLine = endLine(pt)
 	p.peekTok = pt
+	p.peekDoc = ""
+	// doc comments sit on their own lines, so they carry over the newline
+	// ending the statement before them to the declaration that follows
+	if pt.Tok != lex.SEMICOLON {
+		// a run of doc comments only documents a declaration on the line
+		// right after it
+		if pt.Pos.Line == p.docLine+1 {
+			p.peekDoc = strings.Join(p.doc, "\n")
+		}
+		p.doc = nil
+	}
+}
+
+// endLine returns the line tok ends on. A newline ending a statement ends
+// the line it is on rather than the one after it.
+func endLine(tok lex.LexedTok) int {
+	if strings.HasSuffix(tok.Raw, "\n") {
+		return tok.End.Line - 1
+	}
+	return tok.End.Line
 }
 
 func (p *Parser) Errors() []string {
@@ -80,7 +172,7 @@ func (p *Parser) Parse() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for p.curTok.Tok != lex.EOF {
-		stmt := p.parseStatement()
+		stmt := p.parseTerminatedStatement()
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
@@ -90,8 +182,12 @@ func (p *Parser) Parse() *ast.Program {
 	return program
 }
 
-func (p *Parser) e(expected, actual lex.Token) {
-	p.errors = append(p.errors, fmt.Sprintf("expected %s, got %s", expected, actual))
+func (p *Parser) errorf(pos lex.Position, format string, args ...interface{}) {
+	p.errors = append(p.errors, fmt.Sprintf("%s: %s", pos, fmt.Sprintf(format, args...)))
+}
+
+func (p *Parser) e(expected lex.Token, actual lex.LexedTok) {
+	p.errorf(actual.Pos, "expected %s, got %s", expected, actual.Tok)
 }
 
 func (p *Parser) curTokenIs(t lex.Token) bool {
@@ -111,43 +207,398 @@ func (p *Parser) expectPeek(t lex.Token) bool {
 
 func (p *Parser) parseStatement() ast.Statement {
 	// defer untrace(trace("parseStatement"))
+	if !p.curTokenIs(lex.IMPORT) && !p.curTokenIs(lex.SEMICOLON) {
+		p.pastImports = true
+	}
+
 	switch p.curTok.Tok {
+	case lex.IMPORT:
+		if s := p.parseImportDeclaration(); s != nil {
+			return s
+		}
 	case lex.RETURN:
-		return p.parseReturnStatement()
+		if s := p.parseReturnStatement(); s != nil {
+			return s
+		}
 	case lex.VAR:
-		stmt := p.parseVarStatement()
-		return stmt
-	case lex.NEWLINE:
+		if s := p.parseVarStatement(); s != nil {
+			return s
+		}
+	case lex.SEMICOLON:
 		return nil
 	case lex.FUNC:
-		return p.parseFunctionDefinition()
+		if s := p.parseFunctionDefinition(); s != nil {
+			return s
+		}
 	case lex.EFUNC:
-		return p.parseEntrypointFunctionDefinition()
+		if s := p.parseEntrypointFunctionDefinition(); s != nil {
+			return s
+		}
+	case lex.DIRECTIVE:
+		return p.parseDirectives()
+	case lex.WHILE:
+		if s := p.parseWhileStatement(); s != nil {
+			return s
+		}
+	case lex.FOR:
+		return p.parseForStatement()
+	case lex.BREAK, lex.CONTINUE:
+		return p.parseBranchStatement()
+	case lex.IDENT:
+		if p.peekTokenIs(lex.COLON) {
+			if s := p.parseLabeledStatement(); s != nil {
+				return s
+			}
+			return nil
+		}
+		fallthrough
+	default:
+		stmt := p.parseExpressionStatement()
+		if assignOperators[p.peekTok.Tok] {
+			p.nextTok()
+			if s := p.parseAssignStatement(stmt); s != nil {
+				return s
+			}
+			return nil
+		}
+		if stmt.Expression == nil {
+			return nil
+		}
+		return stmt
+	}
+	// a statement that failed to parse is returned as a nil pointer, which
+	// has to be turned into a nil Statement for callers to see it as missing
+	return nil
+}
+
+// parseTerminatedStatement parses a statement, which has to be followed by a
+// semicolon or a newline that ends it, or else by the end of its block or of
+// the file. A statement that is not is reported, and the tokens up to the
+// next of those are skipped.
+func (p *Parser) parseTerminatedStatement() ast.Statement {
+	errs := len(p.errors)
+	stmt := p.parseStatement()
+	// statements that failed to parse have been reported already
+	if len(p.errors) == errs && !p.curTokenIs(lex.SEMICOLON) && !p.peekEndsStatement() {
+		p.errorf(p.peekTok.Pos, "expected ; or newline after statement, got %s", p.peekTok.Tok)
+		for !p.peekEndsStatement() {
+			p.nextTok()
+		}
+	}
+	return stmt
+}
+
+// peekEndsStatement reports whether the next token ends the statement before
+// it.
+func (p *Parser) peekEndsStatement() bool {
+	return p.peekTokenIs(lex.SEMICOLON) || p.peekTokenIs(lex.BLOCKEND) || p.peekTokenIs(lex.EOF)
+}
+
+// parseImportDeclaration parses an import of a package, given by name or as
+// a string, with an optional alias after as. Imports have to come before
+// anything else in a file.
+func (p *Parser) parseImportDeclaration() *ast.ImportDeclaration {
+	// defer untrace(trace("parseImportDeclaration"))
+	decl := &ast.ImportDeclaration{Token: p.curTok}
+	if p.pastImports {
+		p.errorf(decl.Token.Pos, "imports must come before anything else in the file")
+	}
+
+	if !p.expectPeek(lex.IDENT) && !p.expectPeek(lex.STRINGLITERAL) {
+		p.errorf(p.peekTok.Pos, "expected package to import, got %s", p.peekTok.Tok)
+		return nil
+	}
+	decl.Path = p.curTok.Val
+
+	if p.peekTokenIs(lex.AS) {
+		p.nextTok()
+		if !p.expectPeek(lex.IDENT) {
+			p.e(lex.IDENT, p.peekTok)
+			return nil
+		}
+		decl.Alias = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
+	}
+
+	if p.peekTokenIs(lex.SEMICOLON) {
+		p.nextTok()
+	}
+	return decl
+}
+
+// parseDirectives parses a run of directives and the declaration they apply
+// to, which is returned with the directives attached.
+func (p *Parser) parseDirectives() ast.Statement {
+	// defer untrace(trace("parseDirectives"))
+	doc := p.curDoc
+	directives := []*ast.Directive{}
+	for p.curTokenIs(lex.DIRECTIVE) {
+		d := &ast.Directive{Token: p.curTok, Name: p.curTok.Val}
+		if p.peekTokenIs(lex.LPAREN) {
+			p.nextTok()
+			d.Arguments = p.parseCallArguments()
+		}
+		directives = append(directives, d)
+		p.nextTok()
+		for p.curTokenIs(lex.SEMICOLON) {
+			p.nextTok()
+		}
+	}
+
+	stmt := p.parseStatement()
+	switch s := stmt.(type) {
+	case nil:
+		// the declaration failed to parse and has been reported
+	case *ast.FunctionDefinition:
+		s.Directives = directives
+		if s.Doc == "" {
+			s.Doc = doc
+		}
+	case *ast.EntrypointFunctionDefinition:
+		s.Directives = directives
+	case *ast.VarStatement:
+		s.Directives = directives
+		if s.Doc == "" {
+			s.Doc = doc
+		}
 	default:
-		return p.parseExpressionStatement()
+		d := directives[0]
+		p.errorf(d.Token.Pos, "directive @%s must be followed by a declaration", d.Name)
 	}
+	return stmt
 }
 
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	// defer untrace(trace("parseExpressionStatement"))
 	stmt := &ast.ExpressionStatement{Token: p.curTok}
 	stmt.Expression = p.parseExpression(LOWEST)
-	if p.peekTokenIs(lex.NEWLINE) {
+	if p.peekTokenIs(lex.SEMICOLON) {
+		p.nextTok()
+	}
+	return stmt
+}
+
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	// defer untrace(trace("parseWhileStatement"))
+	stmt := &ast.WhileStatement{Token: p.curTok}
+	if !p.expectPeek(lex.LPAREN) {
+		p.e(lex.LPAREN, p.peekTok)
+		return nil
+	}
+	p.nextTok()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if !p.expectPeek(lex.RPAREN) {
+		p.e(lex.RPAREN, p.peekTok)
+		return nil
+	}
+	if !p.expectPeek(lex.BLOCKSTART) {
+		p.e(lex.BLOCKSTART, p.peekTok)
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+	return stmt
+}
+
+// parseForStatement parses any of the three forms of for loop: C-style with
+// an init statement, condition and post statement in parentheses, with only a
+// condition in parentheses, or over a collection or range with in.
+func (p *Parser) parseForStatement() ast.Statement {
+	// defer untrace(trace("parseForStatement"))
+	tok := p.curTok
+	if !p.peekTokenIs(lex.LPAREN) {
+		if s := p.parseForInStatement(); s != nil {
+			return s
+		}
+		return nil
+	}
+	p.nextTok()
+	p.nextTok()
+
+	stmt := &ast.ForStatement{Token: tok}
+	if !p.curTokenIs(lex.SEMICOLON) {
+		stmt.Init = p.parseStatement()
+		// a lone expression is the condition of a ForCondStatement
+		if es, ok := stmt.Init.(*ast.ExpressionStatement); ok && p.peekTokenIs(lex.RPAREN) {
+			p.nextTok()
+			if s := p.parseForCondStatement(tok, es.Expression); s != nil {
+				return s
+			}
+			return nil
+		}
+		// the init statement consumes the ; after it
+		if !p.curTokenIs(lex.SEMICOLON) {
+			p.e(lex.SEMICOLON, p.peekTok)
+			return nil
+		}
+	}
+
+	p.nextTok()
+	if !p.curTokenIs(lex.SEMICOLON) {
+		stmt.Condition = p.parseExpression(LOWEST)
+		if !p.expectPeek(lex.SEMICOLON) {
+			p.e(lex.SEMICOLON, p.peekTok)
+			return nil
+		}
+	}
+
+	p.nextTok()
+	if !p.curTokenIs(lex.RPAREN) {
+		stmt.Post = p.parseStatement()
+		if !p.expectPeek(lex.RPAREN) {
+			p.e(lex.RPAREN, p.peekTok)
+			return nil
+		}
+	}
+
+	if !p.expectPeek(lex.BLOCKSTART) {
+		p.e(lex.BLOCKSTART, p.peekTok)
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+	return stmt
+}
+
+// parseForCondStatement parses the body of a for loop with only a condition,
+// starting at the ) after the condition.
+func (p *Parser) parseForCondStatement(tok lex.LexedTok, cond ast.Expression) *ast.ForCondStatement {
+	// defer untrace(trace("parseForCondStatement"))
+	stmt := &ast.ForCondStatement{Token: tok, Condition: cond}
+	if !p.expectPeek(lex.BLOCKSTART) {
+		p.e(lex.BLOCKSTART, p.peekTok)
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+	return stmt
+}
+
+func (p *Parser) parseForInStatement() *ast.ForInStatement {
+	// defer untrace(trace("parseForInStatement"))
+	stmt := &ast.ForInStatement{Token: p.curTok}
+	if !p.expectPeek(lex.IDENT) {
+		p.e(lex.IDENT, p.peekTok)
+		return nil
+	}
+	stmt.Variable = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
+	if !p.expectPeek(lex.IN) {
+		p.e(lex.IN, p.peekTok)
+		return nil
+	}
+	p.nextTok()
+	stmt.Iterable = p.parseExpression(LOWEST)
+	if !p.expectPeek(lex.BLOCKSTART) {
+		p.e(lex.BLOCKSTART, p.peekTok)
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+	return stmt
+}
+
+// parseLoopBody parses the block of a loop, in which break and continue can
+// be used.
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loops++
+	defer func() { p.loops-- }()
+	return p.parseBlockStatement()
+}
+
+func (p *Parser) parseBranchStatement() *ast.BranchStatement {
+	// defer untrace(trace("parseBranchStatement"))
+	stmt := &ast.BranchStatement{Token: p.curTok}
+	if p.peekTokenIs(lex.IDENT) {
+		p.nextTok()
+		stmt.Label = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
+	}
+
+	if p.loops == 0 {
+		p.errorf(stmt.Token.Pos, "%s is not in a loop", stmt.Token.Val)
+	} else if stmt.Label != nil && !p.inLoopLabeled(stmt.Label.Value) {
+		p.errorf(stmt.Label.Token.Pos, "%s label %s is not on a loop around it", stmt.Token.Val, stmt.Label.Value)
+	}
+
+	if p.peekTokenIs(lex.SEMICOLON) {
+		p.nextTok()
+	}
+	return stmt
+}
+
+// inLoopLabeled reports whether the statement being parsed is inside a loop
+// labelled label.
+func (p *Parser) inLoopLabeled(label string) bool {
+	for _, l := range p.labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLabeledStatement parses a label and the loop it labels.
+func (p *Parser) parseLabeledStatement() *ast.LabeledStatement {
+	// defer untrace(trace("parseLabeledStatement"))
+	stmt := &ast.LabeledStatement{Token: p.curTok}
+	stmt.Label = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
+	p.nextTok()
+	p.nextTok()
+	if !p.curTokenIs(lex.WHILE) && !p.curTokenIs(lex.FOR) {
+		p.errorf(p.curTok.Pos, "label %s must be followed by a loop, got %s", stmt.Label.Value, p.curTok.Tok)
+		return nil
+	}
+
+	p.labels = append(p.labels, stmt.Label.Value)
+	defer func() { p.labels = p.labels[:len(p.labels)-1] }()
+	stmt.Statement = p.parseStatement()
+	return stmt
+}
+
+// parseFunctionBody parses the block of a function. Loops around the
+// function's definition don't carry into its body.
+func (p *Parser) parseFunctionBody() *ast.BlockStatement {
+	loops, labels := p.loops, p.labels
+	p.loops, p.labels = 0, nil
+	defer func() { p.loops, p.labels = loops, labels }()
+	return p.parseBlockStatement()
+}
+
+// parseAssignStatement parses the rest of an assignment to the expression in
+// lhs, starting at the assignment operator.
+func (p *Parser) parseAssignStatement(lhs *ast.ExpressionStatement) *ast.AssignStatement {
+	// defer untrace(trace("parseAssignStatement"))
+	stmt := &ast.AssignStatement{Token: p.curTok, Target: lhs.Expression, Operator: p.curTok.Val}
+	if stmt.Target != nil && !assignable(stmt.Target) {
+		p.errorf(lhs.Token.Pos, "cannot assign to %s", stmt.Target.String())
+	}
+	p.nextTok()
+	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Target == nil || stmt.Value == nil {
+		return nil
+	}
+	if p.peekTokenIs(lex.SEMICOLON) {
 		p.nextTok()
 	}
 	return stmt
 }
 
+// assignable reports whether exp can be assigned to: a variable, an element
+// or a field.
+func assignable(exp ast.Expression) bool {
+	switch exp := exp.(type) {
+	case *ast.GroupedExpression:
+		return exp.Expression != nil && assignable(exp.Expression)
+	case *ast.Identifier, *ast.IndexExpression, *ast.SelectorExpression:
+		return true
+	}
+	return false
+}
+
 func (p *Parser) parseExpression(prec int) ast.Expression {
 	// defer untrace(trace("parseExpression"))
 	prefix := p.prefixParseFuncs[p.curTok.Tok]
 	if prefix == nil {
-		p.noPrefixParseFuncError(p.curTok.Tok)
+		p.noPrefixParseFuncError(p.curTok)
 		return nil
 	}
 	lExp := prefix()
 
-	for !p.peekTokenIs(lex.NEWLINE) && prec < p.peekPrecedence() {
+	for prec < p.peekPrecedence() {
 		infix := p.infixParseFuncs[p.peekTok.Tok]
 		if infix == nil {
 			return lExp
@@ -164,12 +615,41 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curTok}
 	val, err := strconv.ParseInt(p.curTok.Val, 0, 64)
 	if err != nil {
-		p.errors = append(p.errors, fmt.Sprintf("could not parse %q as integer: error: %v", p.curTok.Val, err.Error()))
+		p.errorf(p.curTok.Pos, "could not parse %q as integer: error: %v", p.curTok.Val, err.Error())
 	}
 	lit.Value = val
 	return lit
 }
 
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	// defer untrace(trace("parseFloatLiteral"))
+	lit := &ast.FloatLiteral{Token: p.curTok}
+	val, err := strconv.ParseFloat(p.curTok.Val, 64)
+	if err != nil {
+		p.errorf(p.curTok.Pos, "could not parse %q as float: error: %v", p.curTok.Val, err.Error())
+	}
+	lit.Value = val
+	return lit
+}
+
+func (p *Parser) parseStringLiteral() ast.Expression {
+	// defer untrace(trace("parseStringLiteral"))
+	return &ast.StringLiteral{Token: p.curTok, Value: p.curTok.Val}
+}
+
+func (p *Parser) parseCharLiteral() ast.Expression {
+	// defer untrace(trace("parseCharLiteral"))
+	lit := &ast.CharLiteral{Token: p.curTok}
+	// byte escapes such as '\xff' stand for the byte's value, not a UTF-8
+	// sequence
+	if len(p.curTok.Val) == 1 {
+		lit.Value = rune(p.curTok.Val[0])
+	} else {
+		lit.Value, _ = utf8.DecodeRuneInString(p.curTok.Val)
+	}
+	return lit
+}
+
 func (p *Parser) parsePrefixExpression() ast.Expression {
 	// defer untrace(trace("parsePrefixExpression"))
 	exp := &ast.PrefixExpression{
@@ -194,6 +674,31 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseRangeExpression(start ast.Expression) ast.Expression {
+	// defer untrace(trace("parseRangeExpression"))
+	exp := &ast.RangeExpression{
+		Token:     p.curTok,
+		Start:     start,
+		Inclusive: p.curTokenIs(lex.RANGEINCL),
+	}
+	precedence := p.curPrecedence()
+	p.nextTok()
+	exp.End = p.parseExpression(precedence)
+	return exp
+}
+
+func (p *Parser) parseGroupedExpression() ast.Expression {
+	// defer untrace(trace("parseGroupedExpression"))
+	exp := &ast.GroupedExpression{Token: p.curTok}
+	p.nextTok()
+	exp.Expression = p.parseExpression(LOWEST)
+	if !p.expectPeek(lex.RPAREN) {
+		p.errorf(exp.Token.Pos, "unclosed (: expected %s, got %s at %s", lex.RPAREN, p.peekTok.Tok, p.peekTok.Pos)
+		return nil
+	}
+	return exp
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	// defer untrace(trace("parseBoolean"))
 	return &ast.Boolean{Token: p.curTok, Value: p.curTokenIs(lex.TRUE)}
@@ -220,6 +725,16 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	if p.peekTokenIs(lex.ELSE) {
 		p.nextTok()
 
+		if p.peekTokenIs(lex.IF) {
+			p.nextTok()
+			alt, ok := p.parseIfExpression().(*ast.IfExpression)
+			if !ok {
+				return nil
+			}
+			exp.Alternative = alt
+			return exp
+		}
+
 		if !p.expectPeek(lex.BLOCKSTART) {
 			return nil
 		}
@@ -233,21 +748,12 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curTok}
 	block.Statements = []ast.Statement{}
 	p.nextTok()
-	if p.curTokenIs(lex.NEWLINE) {
-		p.nextTok()
-	}
-	canCont := !p.curTokenIs(lex.BLOCKEND) && !p.curTokenIs(lex.EOF)
-	for canCont {
-		stmt := p.parseStatement()
+	for !p.curTokenIs(lex.BLOCKEND) && !p.curTokenIs(lex.EOF) {
+		stmt := p.parseTerminatedStatement()
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
 		p.nextTok()
-		if p.curTokenIs(lex.NEWLINE) { // blockend was consumed here because it was p.peekTokenIs which ignored the advance on line 243
-			p.nextTok()
-			p.nextTok()
-		}
-		canCont = !p.curTokenIs(lex.BLOCKEND) && !p.curTokenIs(lex.EOF)
 	}
 	return block
 }
@@ -267,23 +773,30 @@ func (p *Parser) parseEntrypointFunctionDefinition() *ast.EntrypointFunctionDefi
 	if !p.expectPeek(lex.BLOCKSTART) {
 		return nil
 	}
-	efd.Body = p.parseBlockStatement()
+	efd.Body = p.parseFunctionBody()
 	return efd
 }
 
 func (p *Parser) parseFunctionDefinition() *ast.FunctionDefinition {
 	// defer untrace(trace("parseFunctionDefinition"))
-	fd := &ast.FunctionDefinition{Token: p.curTok}
+	fd := &ast.FunctionDefinition{Token: p.curTok, Doc: p.curDoc}
 	p.nextTok()
 	fd.Name = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
 	if !p.expectPeek(lex.LPAREN) {
 		return nil
 	}
 	fd.Parameters = p.parseFunctionParameters()
+	if !p.peekTokenIs(lex.BLOCKSTART) {
+		p.nextTok()
+		fd.ReturnType = p.parseType()
+		if fd.ReturnType == nil {
+			return nil
+		}
+	}
 	if !p.expectPeek(lex.BLOCKSTART) {
 		return nil
 	}
-	fd.Body = p.parseBlockStatement()
+	fd.Body = p.parseFunctionBody()
 	return fd
 }
 
@@ -295,61 +808,141 @@ func (p *Parser) parseFunctionParameters() []*ast.Parameter {
 		return parameters
 	}
 	p.nextTok()
+	param := p.parseParameter()
+	if param == nil {
+		return nil
+	}
+	parameters = append(parameters, param)
+
+	for p.peekTokenIs(lex.COMMA) {
+		p.nextTok()
+		p.nextTok()
+		param := p.parseParameter()
+		if param == nil {
+			return nil
+		}
+		parameters = append(parameters, param)
+	}
+	if !p.expectPeek(lex.RPAREN) {
+		return nil
+	}
+	return parameters
+}
+
+// parseParameter parses a parameter, which is written with its type before
+// its name. The name-first order is reported when it can be told apart: when
+// the type is built in, an array type or qualified by its package. Two plain
+// names could be either way round, since either could name a user-defined
+// type, so p Point is taken as a parameter named Point of type p.
+func (p *Parser) parseParameter() *ast.Parameter {
+	// defer untrace(trace("parseParameter"))
+	if p.curTokenIs(lex.IDENT) && (p.peekTokenIs(lex.TYPEANNOT) || p.peekTokenIs(lex.LSQRBRAC)) {
+		name := p.curTok
+		p.nextTok()
+		return p.parseReversedParameter(name)
+	}
 	param := &ast.Parameter{}
-	if !p.curTokenIs(lex.TYPEANNOT) {
-		p.e(lex.TYPEANNOT, p.curTok.Tok)
+	param.Type = p.parseType()
+	if param.Type == nil {
+		return nil
 	}
-	param.Type = &ast.Type{Token: p.curTok, Value: p.curTok.Val}
 	p.nextTok()
 	if !p.curTokenIs(lex.IDENT) {
-		p.e(lex.IDENT, p.curTok.Tok)
+		p.e(lex.IDENT, p.curTok)
+	}
+	// a name followed by a qualified type, as in p geo.Point
+	if t, ok := param.Type.(*ast.Type); ok && t.Token.Tok == lex.IDENT && p.peekTokenIs(lex.DOT) {
+		return p.parseReversedParameter(t.Token)
 	}
 	param.Token = p.curTok
 	param.Name = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
-	parameters = append(parameters, param)
+	return param
+}
 
-	for p.peekTokenIs(lex.COMMA) {
-		p.nextTok()
+// parseReversedParameter parses the type of a parameter written with its name
+// before its type, reporting how it should be written instead.
+func (p *Parser) parseReversedParameter(name lex.LexedTok) *ast.Parameter {
+	// defer untrace(trace("parseReversedParameter"))
+	param := &ast.Parameter{Token: name}
+	param.Name = &ast.Identifier{Token: name, Value: name.Val}
+	param.Type = p.parseType()
+	if param.Type == nil {
+		return nil
+	}
+	p.errorf(param.Token.Pos, "parameter type must come before its name: write %q instead of %q",
+		param.Type.String()+" "+param.Name.Value, param.Name.Value+" "+param.Type.String())
+	return param
+}
+
+// parseType parses the type starting at the current token: a built-in or
+// user-defined type name, a name qualified by its package, or an array type.
+func (p *Parser) parseType() ast.TypeExpr {
+	// defer untrace(trace("parseType"))
+	switch p.curTok.Tok {
+	case lex.TYPEANNOT:
+		return &ast.Type{Token: p.curTok, Value: p.curTok.Val}
+	case lex.IDENT:
+		if !p.peekTokenIs(lex.DOT) {
+			return &ast.Type{Token: p.curTok, Value: p.curTok.Val}
+		}
+		qt := &ast.QualifiedType{Token: p.curTok}
+		qt.Package = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
 		p.nextTok()
-		param := &ast.Parameter{}
-		if !p.curTokenIs(lex.TYPEANNOT) {
-			p.e(lex.TYPEANNOT, p.curTok.Tok)
+		if !p.expectPeek(lex.IDENT) {
+			p.e(lex.IDENT, p.peekTok)
+			return nil
+		}
+		qt.Name = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
+		return qt
+	case lex.LSQRBRAC:
+		at := &ast.ArrayType{Token: p.curTok}
+		if !p.peekTokenIs(lex.RSQRBRAC) {
+			p.nextTok()
+			at.Len = p.parseExpression(LOWEST)
+		}
+		if !p.expectPeek(lex.RSQRBRAC) {
+			p.e(lex.RSQRBRAC, p.peekTok)
+			return nil
 		}
-		param.Type = &ast.Type{Token: p.curTok, Value: p.curTok.Val}
 		p.nextTok()
-		if !p.curTokenIs(lex.IDENT) {
-			p.e(lex.IDENT, p.curTok.Tok)
+		at.Elem = p.parseType()
+		if at.Elem == nil {
+			return nil
 		}
-		param.Token = p.curTok
-		param.Name = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
-		parameters = append(parameters, param)
-	}
-	if !p.expectPeek(lex.RPAREN) {
+		return at
+	default:
+		p.errorf(p.curTok.Pos, "expected type, got %s", p.curTok.Tok)
 		return nil
 	}
-	return parameters
 }
 
 func (p *Parser) parseVarStatement() *ast.VarStatement {
 	// defer untrace(trace("parseVarStatement"))
-	stmt := &ast.VarStatement{Token: p.curTok}
+	stmt := &ast.VarStatement{Token: p.curTok, Doc: p.curDoc}
 
-	if !p.expectPeek(lex.TYPEANNOT) {
-		p.e(lex.TYPEANNOT, p.curTok.Tok)
+	p.nextTok()
+	stmt.Type = p.parseType()
+	if stmt.Type == nil {
+		return nil
 	}
-	stmt.Type = &ast.Type{Token: p.curTok, Value: p.curTok.Val}
 
 	if !p.expectPeek(lex.IDENT) {
-		p.e(lex.IDENT, p.curTok.Tok)
+		p.e(lex.IDENT, p.peekTok)
+		return nil
 	}
 	stmt.Name = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
 
 	if !p.expectPeek(lex.ASSIGN) {
-		p.e(lex.ASSIGN, p.curTok.Tok)
+		p.e(lex.ASSIGN, p.peekTok)
+		return nil
 	}
 	p.nextTok()
-	stmt.Value = p.parseExpressionStatement()
-	if p.peekTokenIs(lex.NEWLINE) {
+	value := p.parseExpressionStatement()
+	if value.Expression == nil {
+		return nil
+	}
+	stmt.Value = value
+	if p.peekTokenIs(lex.SEMICOLON) {
 		p.nextTok()
 	}
 	return stmt
@@ -358,10 +951,12 @@ func (p *Parser) parseVarStatement() *ast.VarStatement {
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	// defer untrace(trace("parseReturnStatement"))
 	stmt := &ast.ReturnStatement{Token: p.curTok}
-	p.nextTok()
-
-	stmt.ReturnValue = p.parseExpression(LOWEST)
-	if p.peekTokenIs(lex.NEWLINE) {
+	// a bare return has no value
+	if !p.peekEndsStatement() {
+		p.nextTok()
+		stmt.ReturnValue = p.parseExpression(LOWEST)
+	}
+	if p.peekTokenIs(lex.SEMICOLON) {
 		p.nextTok()
 	}
 
@@ -380,6 +975,29 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	// defer untrace(trace("parseIndexExpression"))
+	exp := &ast.IndexExpression{Token: p.curTok, Left: left}
+	p.nextTok()
+	exp.Index = p.parseExpression(LOWEST)
+	if !p.expectPeek(lex.RSQRBRAC) {
+		p.e(lex.RSQRBRAC, p.peekTok)
+		return nil
+	}
+	return exp
+}
+
+func (p *Parser) parseSelectorExpression(left ast.Expression) ast.Expression {
+	// defer untrace(trace("parseSelectorExpression"))
+	exp := &ast.SelectorExpression{Token: p.curTok, Left: left}
+	if !p.expectPeek(lex.IDENT) {
+		p.e(lex.IDENT, p.peekTok)
+		return nil
+	}
+	exp.Selector = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
+	return exp
+}
+
 func (p *Parser) parseCallArguments() []ast.Expression {
 	// defer untrace(trace("parseCallArguments"))
 	args := []ast.Expression{}
@@ -6,6 +6,12 @@ import (
 	"github.com/westsi/molybdenum/lex"
 )
 
+// tokenReader is a source of tokens for the parser. Once the tokens run out
+// it keeps returning EOF.
+type tokenReader interface {
+	Read() lex.LexedTok
+}
+
 type ParseReader struct {
 	tokens []lex.LexedTok
 	idx    int
@@ -39,3 +45,16 @@ func (p *ParseReader) PrintRem() {
 		fmt.Print(", ")
 	}
 }
+
+// lexReader reads tokens from a lexer as they are needed.
+type lexReader struct {
+	l *lex.Lexer
+}
+
+func (r *lexReader) Read() lex.LexedTok {
+	tok, ok := r.l.Next()
+	if !ok {
+		return lex.LexedTok{Pos: lex.Position{}, Tok: lex.EOF, Val: ""}
+	}
+	return tok
+}
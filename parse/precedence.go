@@ -5,24 +5,37 @@ import "github.com/westsi/molybdenum/lex"
 const (
 	_ = iota
 	LOWEST
+	LOGICALOR
+	LOGICALAND
 	EQUALS
 	LESSGREATER
+	RANGE
 	SUM
 	PRODUCT
 	PREFIX
 	CALL
+	INDEX
 )
 
 var precedences = map[lex.Token]int{
+	lex.OR:        LOGICALOR,
+	lex.AND:       LOGICALAND,
 	lex.EQUALS:    EQUALS,
 	lex.NOTEQUALS: EQUALS,
 	lex.LT:        LESSGREATER,
 	lex.GT:        LESSGREATER,
+	lex.LTE:       LESSGREATER,
+	lex.GTE:       LESSGREATER,
+	lex.RANGE:     RANGE,
+	lex.RANGEINCL: RANGE,
 	lex.ADD:       SUM,
 	lex.SUB:       SUM,
 	lex.MUL:       PRODUCT,
 	lex.DIV:       PRODUCT,
+	lex.MOD:       PRODUCT,
 	lex.LPAREN:    CALL,
+	lex.LSQRBRAC:  INDEX,
+	lex.DOT:       INDEX,
 }
 
 func (p *Parser) peekPrecedence() int {